@@ -0,0 +1,42 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"syscall"
+)
+
+var errBindDeviceUnsupported = errors.New("binding to an interface needs a raw IP socket")
+
+// canBindDevice reports whether bindToDevice can force a socket's packets
+// out of a particular interface.
+const canBindDevice = true
+
+// bindToDevice sets IP_BOUND_IF or IPV6_BOUND_IF on c, so that its packets
+// leave through the interface named device whatever the routing table says.
+func bindToDevice(c net.PacketConn, device string, ipv6 bool) error {
+	ifi, err := net.InterfaceByName(device)
+	if err != nil {
+		return err
+	}
+	ipc, ok := c.(*net.IPConn)
+	if !ok {
+		return errBindDeviceUnsupported
+	}
+	rc, err := ipc.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var serr error
+	err = rc.Control(func(fd uintptr) {
+		if ipv6 {
+			serr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_BOUND_IF, ifi.Index)
+		} else {
+			serr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_BOUND_IF, ifi.Index)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return serr
+}
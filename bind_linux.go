@@ -0,0 +1,34 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"syscall"
+)
+
+var errBindDeviceUnsupported = errors.New("binding to an interface needs a raw IP socket")
+
+// canBindDevice reports whether bindToDevice can force a socket's packets
+// out of a particular interface.
+const canBindDevice = true
+
+// bindToDevice sets SO_BINDTODEVICE on c, so that its packets leave through
+// the interface named device whatever the routing table says.
+func bindToDevice(c net.PacketConn, device string, ipv6 bool) error {
+	ipc, ok := c.(*net.IPConn)
+	if !ok {
+		return errBindDeviceUnsupported
+	}
+	rc, err := ipc.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var serr error
+	err = rc.Control(func(fd uintptr) {
+		serr = syscall.BindToDevice(int(fd), device)
+	})
+	if err != nil {
+		return err
+	}
+	return serr
+}
@@ -0,0 +1,20 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package main
+
+import (
+	"errors"
+	"net"
+)
+
+var errBindDeviceUnsupported = errors.New("binding to an interface is not supported on this platform")
+
+// canBindDevice reports whether bindToDevice can force a socket's packets
+// out of a particular interface. Here it cannot, so --listen-all-interfaces
+// only picks each interface's source address, and labels results with it.
+const canBindDevice = false
+
+func bindToDevice(c net.PacketConn, device string, ipv6 bool) error {
+	return errBindDeviceUnsupported
+}
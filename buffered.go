@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// stdout is where results are written; it is buffered with
+// --output-buffered.
+var stdout io.Writer = os.Stdout
+
+// flushInterval is how often buffered output is flushed.
+const flushInterval = 200 * time.Millisecond
+
+// syncWriter is a bufio.Writer that can be written to and flushed from
+// different goroutines.
+type syncWriter struct {
+	mu sync.Mutex
+	w  *bufio.Writer
+}
+
+func (s *syncWriter) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.w.Write(p)
+}
+
+func (s *syncWriter) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.w.Flush()
+}
+
+var buffered []*syncWriter
+
+// startBufferedOutput buffers both the log output and stdout, flushing them
+// periodically, on exit, and when interrupted, so that writing results
+// doesn't block the goroutines producing them.
+func startBufferedOutput() {
+	errw := &syncWriter{w: bufio.NewWriter(os.Stderr)}
+	outw := &syncWriter{w: bufio.NewWriter(os.Stdout)}
+	buffered = []*syncWriter{errw, outw}
+	log.SetOutput(errw)
+	stdout = outw
+
+	go func() {
+		t := clock.NewTicker(flushInterval)
+		defer t.Stop()
+		for range t.C() {
+			flushOutput()
+		}
+	}()
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		exit(130)
+	}()
+}
+
+// flushOutput writes out anything buffered by --output-buffered.
+func flushOutput() {
+	for _, w := range buffered {
+		w.Flush()
+	}
+}
+
+// exit reports any errors held back by --quiet-errors, flushes any
+// buffered output and exits with the given status. It
+// should be used instead of os.Exit once pinging has started.
+func exit(code int) {
+	reportRepeatedErrors()
+	flushOutput()
+	os.Exit(code)
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"log"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+// benchmarkReport reports a reply from parallel goroutines with log output
+// going to w, which is what --output-buffered speeds up.
+func benchmarkReport(b *testing.B, w io.Writer) {
+	log.SetOutput(w)
+	defer log.SetOutput(os.Stderr)
+	r := result{Host: "192.0.2.1", IP: net.ParseIP("192.0.2.1"), RTT: 1234 * time.Microsecond, Status: statusReply}
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			report(r)
+		}
+	})
+}
+
+func openDevNull(b *testing.B) *os.File {
+	f, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { f.Close() })
+	return f
+}
+
+func BenchmarkReportUnbuffered(b *testing.B) {
+	benchmarkReport(b, openDevNull(b))
+}
+
+func BenchmarkReportBuffered(b *testing.B) {
+	w := &syncWriter{w: bufio.NewWriter(openDevNull(b))}
+	benchmarkReport(b, w)
+	w.Flush()
+}
@@ -0,0 +1,30 @@
+package main
+
+import "time"
+
+// Clock is the source of time used for RTT measurement and scheduling. It
+// exists so that tests can substitute a fake clock; socket deadlines still
+// use the real time, since the kernel enforces them.
+type Clock interface {
+	Now() time.Time
+	NewTicker(d time.Duration) Ticker
+}
+
+// Ticker is the subset of *time.Ticker used through a Clock.
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// clock is the Clock used throughout quickping.
+var clock Clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                   { return time.Now() }
+func (realClock) NewTicker(d time.Duration) Ticker { return realTicker{time.NewTicker(d)} }
+
+type realTicker struct{ t *time.Ticker }
+
+func (t realTicker) C() <-chan time.Time { return t.t.C }
+func (t realTicker) Stop()               { t.t.Stop() }
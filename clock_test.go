@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// fakeClock is a Clock that only moves when told to. Its tickers never
+// fire.
+type fakeClock struct{ now time.Time }
+
+func (c *fakeClock) Now() time.Time                   { return c.now }
+func (c *fakeClock) NewTicker(d time.Duration) Ticker { return fakeTicker{} }
+
+type fakeTicker struct{}
+
+func (fakeTicker) C() <-chan time.Time { return nil }
+func (fakeTicker) Stop()               {}
+
+// useFakeClock replaces clock with a fakeClock at start for the rest of t.
+func useFakeClock(t *testing.T, start time.Time) *fakeClock {
+	c := &fakeClock{now: start}
+	old := clock
+	clock = c
+	t.Cleanup(func() { clock = old })
+	return c
+}
+
+// steppingReader advances clk by step before each read, standing in for
+// the time spent waiting on a socket with no kernel timestamps.
+type steppingReader struct {
+	packetReader
+	clk  *fakeClock
+	step time.Duration
+}
+
+func (r steppingReader) ReadFrom(b []byte) (int, replyMeta, net.Addr, error) {
+	r.clk.now = r.clk.now.Add(r.step)
+	return r.packetReader.ReadFrom(b)
+}
+
+// TestRTTFromClock checks that without receive timestamps, RTTs are
+// measured with the Clock.
+func TestRTTFromClock(t *testing.T) {
+	start := time.Unix(1000, 0)
+	clk := useFakeClock(t, start)
+
+	req := testRequest("192.0.2.1", 0x1234, 1, start)
+	pr := steppingReader{
+		packetReader: &fakeReader{packets: []fakePacket{
+			{b: echoReply(t, 0x9999, 1), peer: "192.0.2.1"},
+			{b: echoReply(t, 0x1234, 1), peer: "192.0.2.1"},
+		}},
+		clk:  clk,
+		step: 4 * time.Millisecond,
+	}
+	r, err := readReply(pr, familyIPv4, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Status != statusReply || r.RTT != 8*time.Millisecond {
+		t.Errorf("got %s in %s; want reply in 8ms", r.Status, r.RTT)
+	}
+
+	req = testRequest("192.0.2.1", 0x1234, 2, clk.Now())
+	pr.packetReader = &fakeReader{}
+	r, err = readReply(pr, familyIPv4, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Status != statusTimeout || r.RTT != 4*time.Millisecond {
+		t.Errorf("got %s in %s; want timeout in 4ms", r.Status, r.RTT)
+	}
+}
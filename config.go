@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	flag "github.com/spf13/pflag"
+)
+
+// configValue is the effective value of a single flag, as printed by
+// --print-config.
+type configValue struct {
+	Value  string `json:"value"`
+	Source string `json:"source"` // "default", "config", "env" or "flag"
+}
+
+// envPrefix is prepended to the upper-cased flag name, with dashes replaced
+// by underscores, to form the environment variable for that flag.
+const envPrefix = "QUICKPING_"
+
+// envFlags records the flags whose values came from the environment.
+var envFlags = make(map[string]string)
+
+// envName returns the environment variable that configures the named flag.
+func envName(name string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// applyEnv sets every flag not given on the command line from its
+// QUICKPING_ environment variable, if set. Command-line flags therefore take
+// precedence over the environment, which takes precedence over defaults.
+func applyEnv() error {
+	var err error
+	flag.VisitAll(func(f *flag.Flag) {
+		if err != nil || f.Changed {
+			return
+		}
+		env := envName(f.Name)
+		v, ok := os.LookupEnv(env)
+		if !ok {
+			return
+		}
+		if serr := flag.Set(f.Name, v); serr != nil {
+			err = fmt.Errorf("invalid value %q for %s: %v", v, env, serr)
+			return
+		}
+		envFlags[f.Name] = env
+	})
+	return err
+}
+
+// printConfig writes the effective value of every flag to stdout as JSON.
+func printConfig() error {
+	config := make(map[string]configValue)
+	flag.VisitAll(func(f *flag.Flag) {
+		source := "default"
+		if _, ok := envFlags[f.Name]; ok {
+			source = "env"
+		} else if configFlags[f.Name] {
+			source = "config"
+		} else if f.Changed {
+			source = "flag"
+		}
+		config[f.Name] = configValue{Value: f.Value.String(), Source: source}
+	})
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(config)
+}
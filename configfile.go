@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	flag "github.com/spf13/pflag"
+)
+
+// configFlags records the flags whose values came from the --config file.
+var configFlags = make(map[string]bool)
+
+// configTargets holds the targets listed in the --config file.
+var configTargets []string
+
+// applyConfigFile sets every flag not given on the command line or in the
+// environment from the file at path, and stores its target list in
+// configTargets.
+//
+// The file uses a subset of TOML: one "key = value" per line, where the key
+// is a flag name (dashes or underscores) or "targets", and the value is a
+// quoted string, a bare number or boolean, or an array of strings for
+// targets. Arrays may span several lines. Comments start with '#'.
+func applyConfigFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	var lineno int
+	for sc.Scan() {
+		lineno++
+		line := strings.TrimSpace(stripComment(sc.Text()))
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			return fmt.Errorf("%s:%d: tables are not supported", path, lineno)
+		}
+		eq := strings.IndexByte(line, '=')
+		if eq < 0 {
+			return fmt.Errorf("%s:%d: expected key = value", path, lineno)
+		}
+		key := strings.TrimSpace(line[:eq])
+		value := strings.TrimSpace(line[eq+1:])
+
+		if strings.HasPrefix(value, "[") {
+			start := lineno
+			for !strings.HasSuffix(value, "]") && sc.Scan() {
+				lineno++
+				value += " " + strings.TrimSpace(stripComment(sc.Text()))
+			}
+			if !strings.HasSuffix(value, "]") {
+				return fmt.Errorf("%s:%d: unterminated array", path, start)
+			}
+			if key != "targets" {
+				return fmt.Errorf("%s:%d: only targets can be an array", path, start)
+			}
+			targets, err := parseConfigArray(value)
+			if err != nil {
+				return fmt.Errorf("%s:%d: %v", path, start, err)
+			}
+			configTargets = append(configTargets, targets...)
+			continue
+		}
+
+		name := strings.ReplaceAll(key, "_", "-")
+		fl := flag.Lookup(name)
+		if fl == nil || name == "config" {
+			return fmt.Errorf("%s:%d: unknown option %q", path, lineno, key)
+		}
+		v, err := parseConfigValue(value)
+		if err != nil {
+			return fmt.Errorf("%s:%d: %v", path, lineno, err)
+		}
+		if fl.Changed {
+			continue
+		}
+		if err := flag.Set(name, v); err != nil {
+			return fmt.Errorf("%s:%d: invalid value %q for %s: %v", path, lineno, v, key, err)
+		}
+		configFlags[name] = true
+	}
+	return sc.Err()
+}
+
+// stripComment removes a trailing '#' comment from line, ignoring any '#'
+// inside a quoted string.
+func stripComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// parseConfigValue returns the flag value for a scalar TOML value.
+func parseConfigValue(s string) (string, error) {
+	switch {
+	case strings.HasPrefix(s, `"`):
+		v, err := strconv.Unquote(s)
+		if err != nil {
+			return "", fmt.Errorf("invalid string %s", s)
+		}
+		return v, nil
+	case strings.HasPrefix(s, "'"):
+		if len(s) < 2 || !strings.HasSuffix(s, "'") {
+			return "", fmt.Errorf("invalid string %s", s)
+		}
+		return s[1 : len(s)-1], nil
+	case s == "":
+		return "", fmt.Errorf("missing value")
+	}
+	return s, nil
+}
+
+// parseConfigArray parses a TOML array of strings.
+func parseConfigArray(s string) ([]string, error) {
+	s = strings.TrimSpace(strings.TrimSuffix(strings.TrimPrefix(s, "["), "]"))
+	var items []string
+	for s != "" {
+		var item string
+		switch s[0] {
+		case '"':
+			end := 1
+			for end < len(s) && s[end] != '"' {
+				if s[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(s) {
+				return nil, fmt.Errorf("unterminated string in array")
+			}
+			v, err := strconv.Unquote(s[:end+1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid string %s", s[:end+1])
+			}
+			item, s = v, s[end+1:]
+		case '\'':
+			end := strings.IndexByte(s[1:], '\'')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated string in array")
+			}
+			item, s = s[1:end+1], s[end+2:]
+		default:
+			return nil, fmt.Errorf("targets must be strings")
+		}
+		items = append(items, item)
+		s = strings.TrimSpace(s)
+		if strings.HasPrefix(s, ",") {
+			s = strings.TrimSpace(s[1:])
+		} else if s != "" {
+			return nil, fmt.Errorf("expected , between array items")
+		}
+	}
+	return items, nil
+}
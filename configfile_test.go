@@ -0,0 +1,74 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseConfigArray(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    []string
+		wantErr bool
+	}{
+		{in: `[]`, want: nil},
+		{in: `["a", "b"]`, want: []string{"a", "b"}},
+		{in: `[ 'a' ,"b",]`, want: []string{"a", "b"}},
+		{in: `["a\"b", 'c\d']`, want: []string{`a"b`, `c\d`}},
+		{in: `["a, b"]`, want: []string{"a, b"}},
+		{in: `["a" "b"]`, wantErr: true},
+		{in: `["a`, wantErr: true},
+		{in: `['a`, wantErr: true},
+		{in: `[1, 2]`, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseConfigArray(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseConfigArray(%s): got error %v; want error %t", tt.in, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseConfigArray(%s) = %q; want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestApplyConfigFileTargets(t *testing.T) {
+	defer func(v []string) { configTargets = v }(configTargets)
+
+	tests := []struct {
+		file    string
+		want    []string
+		wantErr string
+	}{
+		{file: "targets = [\"a\", \"b\"]\n", want: []string{"a", "b"}},
+		{file: "targets = [\n  \"a\", # first\n  \"b\",\n]\n", want: []string{"a", "b"}},
+		{file: "targets = [\"a\", \"b\"", wantErr: "unterminated array"},
+		{file: "targets = [\n  \"a\",\n  \"b\"\n", wantErr: "unterminated array"},
+		{file: "count = [\"a\"]\n", wantErr: "only targets can be an array"},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), "quickping.toml")
+		if err := os.WriteFile(path, []byte(tt.file), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		configTargets = nil
+		err := applyConfigFile(path)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("applyConfigFile(%q): got error %v; want %q", tt.file, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("applyConfigFile(%q): %v", tt.file, err)
+			continue
+		}
+		if !reflect.DeepEqual(configTargets, tt.want) {
+			t.Errorf("applyConfigFile(%q): targets %q; want %q", tt.file, configTargets, tt.want)
+		}
+	}
+}
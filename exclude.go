@@ -0,0 +1,71 @@
+package main
+
+import (
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	excludedMu sync.Mutex
+	excluded   = make(map[string]int) // reason -> number of addresses
+)
+
+// excludeReason returns why ip is skipped by --exclude-loopback or
+// --exclude-private, or "" if it should be pinged.
+func excludeReason(ip net.IP) string {
+	if *flagExcludeLoopback {
+		if ip.IsLoopback() {
+			return "loopback"
+		}
+		if ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() {
+			return "link-local"
+		}
+	}
+	if *flagExcludePrivate && ip.IsPrivate() {
+		return "private"
+	}
+	return ""
+}
+
+// filterExcluded returns the addresses of addr that are not excluded,
+// counting the rest for logExcluded.
+func filterExcluded(addr string, ips []net.IP) []net.IP {
+	if !*flagExcludeLoopback && !*flagExcludePrivate {
+		return ips
+	}
+	var kept []net.IP
+	for _, ip := range ips {
+		reason := excludeReason(ip)
+		if reason == "" {
+			kept = append(kept, ip)
+			continue
+		}
+		if *flagVerbose {
+			log.Printf("[%s] %s: excluded (%s)", addr, ip, reason)
+		}
+		excludedMu.Lock()
+		excluded[reason]++
+		excludedMu.Unlock()
+	}
+	return kept
+}
+
+// logExcluded logs how many addresses were skipped, and why.
+func logExcluded() {
+	excludedMu.Lock()
+	defer excludedMu.Unlock()
+	var total int
+	var reasons []string
+	for _, reason := range []string{"loopback", "link-local", "private"} {
+		if n := excluded[reason]; n > 0 {
+			total += n
+			reasons = append(reasons, strconv.Itoa(n)+" "+reason)
+		}
+	}
+	if total > 0 {
+		log.Printf("excluded %d addresses: %s", total, strings.Join(reasons, ", "))
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// maxExpansion caps the number of targets a single template may produce.
+const maxExpansion = 65536
+
+var (
+	// braceRange matches a bash-style numeric range, e.g. "{1..50}".
+	braceRange = regexp.MustCompile(`\{(\d+)\.\.(\d+)\}`)
+
+	// printfRange matches a printf-style template followed by a range,
+	// e.g. "node-%03d:1-50".
+	printfRange = regexp.MustCompile(`^(.*%0?\d*d.*):(\d+)-(\d+)$`)
+)
+
+// expandTargets expands any templated arguments into the full list of
+// targets they describe. Arguments without a template are passed through
+// unchanged.
+func expandTargets(args []string) ([]string, error) {
+	var targets []string
+	for _, arg := range args {
+		expanded, err := expandTemplate(arg)
+		if err != nil {
+			return nil, fmt.Errorf("invalid target template %q: %v", arg, err)
+		}
+		targets = append(targets, expanded...)
+	}
+	return targets, nil
+}
+
+func expandTemplate(arg string) ([]string, error) {
+	if out, ok, err := expandIPRange(arg); ok {
+		return out, err
+	}
+
+	if m := printfRange.FindStringSubmatch(arg); m != nil {
+		if err := checkPrintfTemplate(m[1]); err != nil {
+			return nil, err
+		}
+		start, end, err := parseRange(m[2], m[3], 1)
+		if err != nil {
+			return nil, err
+		}
+		var out []string
+		for i := 0; i <= end-start; i++ {
+			out = append(out, fmt.Sprintf(m[1], start+i))
+		}
+		return out, nil
+	}
+
+	loc := braceRange.FindStringSubmatchIndex(arg)
+	if loc == nil {
+		return []string{arg}, nil
+	}
+
+	lo, hi := arg[loc[2]:loc[3]], arg[loc[4]:loc[5]]
+	prefix, rest := arg[:loc[0]], arg[loc[1]:]
+
+	// Expand any further ranges in the remainder first, so that the cap
+	// applies to the full cross product.
+	suffixes, err := expandTemplate(rest)
+	if err != nil {
+		return nil, err
+	}
+	start, end, err := parseRange(lo, hi, len(suffixes))
+	if err != nil {
+		return nil, err
+	}
+
+	// As in bash, a leading zero on either bound pads every value to the
+	// width of the longer bound.
+	width := 0
+	if (len(lo) > 1 && lo[0] == '0') || (len(hi) > 1 && hi[0] == '0') {
+		width = len(lo)
+		if len(hi) > width {
+			width = len(hi)
+		}
+	}
+
+	var out []string
+	for i := 0; i <= end-start; i++ {
+		for _, suffix := range suffixes {
+			out = append(out, fmt.Sprintf("%s%0*d%s", prefix, width, start+i, suffix))
+		}
+	}
+	return out, nil
+}
+
+// checkPrintfTemplate checks that a printf-style template has exactly one
+// integer verb, %d or %0Nd, and no other verbs than %%.
+func checkPrintfTemplate(t string) error {
+	verbs := 0
+	for i := 0; i < len(t); i++ {
+		if t[i] != '%' {
+			continue
+		}
+		j := i + 1
+		if j < len(t) && t[j] == '%' {
+			i = j
+			continue
+		}
+		if j < len(t) && t[j] == '0' {
+			j++
+		}
+		for j < len(t) && t[j] >= '0' && t[j] <= '9' {
+			j++
+		}
+		if j >= len(t) || t[j] != 'd' {
+			return fmt.Errorf("only %%d or %%0Nd and %%%% may appear in the template")
+		}
+		verbs++
+		i = j
+	}
+	if verbs != 1 {
+		return fmt.Errorf("template must have exactly one %%d, not %d", verbs)
+	}
+	return nil
+}
+
+// parseRange parses the bounds of a numeric range and checks that it is
+// well-formed and that, multiplied by the given number of combinations, it
+// stays within maxExpansion.
+func parseRange(lo, hi string, combinations int) (int, int, error) {
+	start, err := strconv.Atoi(lo)
+	if err != nil {
+		return 0, 0, err
+	}
+	end, err := strconv.Atoi(hi)
+	if err != nil {
+		return 0, 0, err
+	}
+	if start > end {
+		return 0, 0, fmt.Errorf("range start %d is after end %d", start, end)
+	}
+	// Checked by division, since the product can overflow.
+	if end-start >= maxExpansion/combinations {
+		return 0, 0, fmt.Errorf("expands to more than %d targets", maxExpansion)
+	}
+	return start, end, nil
+}
+
+// expandIPRange expands an address range such as "192.168.1.10-192.168.1.50",
+// "192.168.1.10-50" (last-octet shorthand) or "2001:db8::1-2001:db8::ff".
+// It reports false if arg is not an address range at all, so that names
+// containing dashes are left alone.
+func expandIPRange(arg string) ([]string, bool, error) {
+	i := strings.IndexByte(arg, '-')
+	if i < 0 {
+		return nil, false, nil
+	}
+	start := net.ParseIP(arg[:i])
+	if start == nil {
+		return nil, false, nil
+	}
+	end := net.ParseIP(arg[i+1:])
+	if end == nil && start.To4() != nil {
+		if n, err := strconv.Atoi(arg[i+1:]); err == nil {
+			if n < 0 || n > 255 {
+				return nil, true, fmt.Errorf("last octet %d is out of range", n)
+			}
+			end = append(net.IP{}, start.To4()...)
+			end[3] = byte(n)
+		}
+	}
+	if end == nil {
+		return nil, false, nil
+	}
+
+	if (start.To4() == nil) != (end.To4() == nil) {
+		return nil, true, fmt.Errorf("range mixes IPv4 and IPv6")
+	}
+	if start.To4() != nil {
+		start, end = start.To4(), end.To4()
+	}
+	if bytes.Compare(start, end) > 0 {
+		return nil, true, fmt.Errorf("range start %s is after end %s", start, end)
+	}
+
+	var out []string
+	for ip := append(net.IP{}, start...); ; incIP(ip) {
+		if len(out) == maxExpansion {
+			return nil, true, fmt.Errorf("expands to more than %d targets", maxExpansion)
+		}
+		out = append(out, ip.String())
+		if ip.Equal(end) {
+			return out, true, nil
+		}
+	}
+}
+
+// incIP increments ip in place.
+func incIP(ip net.IP) {
+	for i := len(ip) - 1; i >= 0; i-- {
+		ip[i]++
+		if ip[i] != 0 {
+			return
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+var expandTests = []struct {
+	args    []string
+	want    []string
+	wantErr bool
+}{
+	{args: []string{"example.com"}, want: []string{"example.com"}},
+	{args: []string{"web{1..3}"}, want: []string{"web1", "web2", "web3"}},
+	{args: []string{"web{08..10}"}, want: []string{"web08", "web09", "web10"}},
+	{args: []string{"r{1..2}-{1..2}"}, want: []string{"r1-1", "r1-2", "r2-1", "r2-2"}},
+	{args: []string{"node-%03d:9-11"}, want: []string{"node-009", "node-010", "node-011"}},
+	{args: []string{"a", "b{1..2}"}, want: []string{"a", "b1", "b2"}},
+	{args: []string{"192.0.2.254-192.0.3.1"}, want: []string{"192.0.2.254", "192.0.2.255", "192.0.3.0", "192.0.3.1"}},
+	{args: []string{"192.0.2.10-12"}, want: []string{"192.0.2.10", "192.0.2.11", "192.0.2.12"}},
+	{args: []string{"2001:db8::fe-2001:db8::101"}, want: []string{"2001:db8::fe", "2001:db8::ff", "2001:db8::100", "2001:db8::101"}},
+	{args: []string{"my-host"}, want: []string{"my-host"}},
+	{args: []string{"192.0.2.10-256"}, wantErr: true},
+	{args: []string{"192.0.2.10-192.0.2.1"}, wantErr: true},
+	{args: []string{"192.0.2.1-2001:db8::1"}, wantErr: true},
+	{args: []string{"100%%-%d:1-2"}, want: []string{"100%-1", "100%-2"}},
+	{args: []string{"a%db%d:1-2"}, wantErr: true},
+	{args: []string{"%s%d:1-2"}, wantErr: true},
+	{args: []string{"h{0..9223372036854775807}"}, wantErr: true},
+	{args: []string{"h%d:0-9223372036854775807"}, wantErr: true},
+	{args: []string{"h{1..65537}"}, wantErr: true},
+	{args: []string{"web{3..1}"}, wantErr: true},
+	{args: []string{"h{1..300}{1..300}"}, wantErr: true},
+}
+
+func TestExpandTargets(t *testing.T) {
+	for _, tt := range expandTests {
+		got, err := expandTargets(tt.args)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("expandTargets(%q): got error %v; want error %t", tt.args, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("expandTargets(%q) = %q; want %q", tt.args, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+)
+
+// padOptionsHeader is an 8-byte Hop-by-Hop or Destination Options header
+// carrying only a PadN option. The kernel fills in the Next Header field.
+var padOptionsHeader = []byte{0, 0, 1, 4, 0, 0, 0, 0}
+
+// setIPv6ExtHeader makes every packet sent on c carry the extension header
+// named by kind, "hop-by-hop" or "dst-opts", for --ipv6-ext-header.
+func setIPv6ExtHeader(c net.PacketConn, kind string) error {
+	var opt int
+	switch kind {
+	case "hop-by-hop":
+		opt = syscall.IPV6_HOPOPTS
+	case "dst-opts":
+		opt = syscall.IPV6_DSTOPTS
+	default:
+		return fmt.Errorf("unknown extension header %q", kind)
+	}
+	ipc, ok := c.(*net.IPConn)
+	if !ok {
+		return fmt.Errorf("extension headers need a raw IP socket")
+	}
+	rc, err := ipc.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var serr error
+	err = rc.Control(func(fd uintptr) {
+		serr = syscall.SetsockoptString(int(fd), syscall.IPPROTO_IPV6, opt, string(padOptionsHeader))
+	})
+	if err != nil {
+		return err
+	}
+	return serr
+}
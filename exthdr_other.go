@@ -0,0 +1,13 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"errors"
+	"net"
+)
+
+func setIPv6ExtHeader(c net.PacketConn, kind string) error {
+	return errors.New("--ipv6-ext-header is only supported on Linux")
+}
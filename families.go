@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+)
+
+// familyUnavailable maps the address families, "ipv4" or "ipv6", for which
+// no ICMP socket could be opened to why not. Addresses in them are skipped.
+var familyUnavailable = make(map[string]string)
+
+// familyWarned holds the families already warned about by availableIPs.
+var (
+	familyWarnedMu sync.Mutex
+	familyWarned   = make(map[string]bool)
+)
+
+// checkFamilies opens a throwaway ICMP socket for each family, so that a
+// family disabled on this host is reported by a single warning, when an
+// address in it is first skipped, instead of an error for every address in
+// it. If neither family works, it prints the errors with any advice and
+// exits.
+func checkFamilies() {
+	var errs []string
+	for _, f := range []struct{ name, network, listen string }{
+		{"ipv4", "ip4:icmp", *flagListen4},
+		{"ipv6", "ip6:icmp", *flagListen6},
+	} {
+		c, err := net.ListenPacket(f.network, f.listen)
+		if err != nil {
+			msg := err.Error()
+			if advice := selfTestAdvice(err); advice != "" {
+				msg += " (" + advice + ")"
+			}
+			familyUnavailable[f.name] = msg
+			errs = append(errs, f.name+": "+msg)
+			continue
+		}
+		c.Close()
+	}
+	if len(errs) == 2 {
+		fmt.Fprintf(os.Stderr, "cannot open an ICMP socket for either family:\n  %s\n", strings.Join(errs, "\n  "))
+		os.Exit(1)
+	}
+}
+
+// availableIPs returns the addresses of ips in a family that checkFamilies
+// could open a socket for, warning the first time a family's addresses are
+// dropped. It is an error if there are none left.
+func availableIPs(ips []net.IP) ([]net.IP, error) {
+	if len(familyUnavailable) == 0 {
+		return ips, nil
+	}
+	var keep []net.IP
+	for _, ip := range ips {
+		family := ipFamily(ip)
+		why, skip := familyUnavailable[family]
+		if !skip {
+			keep = append(keep, ip)
+			continue
+		}
+		familyWarnedMu.Lock()
+		if !familyWarned[family] {
+			familyWarned[family] = true
+			log.Printf("warning: skipping %s addresses: %s", family, why)
+		}
+		familyWarnedMu.Unlock()
+	}
+	if len(keep) == 0 {
+		return nil, fmt.Errorf("no addresses in an available family")
+	}
+	return keep, nil
+}
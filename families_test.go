@@ -0,0 +1,50 @@
+package main
+
+import (
+	"io"
+	"log"
+	"net"
+	"os"
+	"reflect"
+	"testing"
+)
+
+// TestAvailableIPs checks which addresses are kept when only one family
+// can open a socket.
+func TestAvailableIPs(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+	defer func() {
+		familyUnavailable = make(map[string]string)
+		familyWarned = make(map[string]bool)
+	}()
+
+	v4, v6 := net.ParseIP("192.0.2.1").To4(), net.ParseIP("2001:db8::1")
+	tests := []struct {
+		name        string
+		unavailable string
+		ips, want   []net.IP
+		wantErr     bool
+	}{
+		{"both available", "", []net.IP{v4, v6}, []net.IP{v4, v6}, false},
+		{"IPv4 only, dual-stack host", "ipv6", []net.IP{v4, v6}, []net.IP{v4}, false},
+		{"IPv6 only, dual-stack host", "ipv4", []net.IP{v4, v6}, []net.IP{v6}, false},
+		{"IPv4 only, IPv6 host", "ipv6", []net.IP{v6}, nil, true},
+		{"IPv6 only, IPv4 host", "ipv4", []net.IP{v4}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			familyUnavailable = make(map[string]string)
+			if tt.unavailable != "" {
+				familyUnavailable[tt.unavailable] = "disabled"
+			}
+			got, err := availableIPs(tt.ips)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v; want error %t", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v; want %v", got, tt.want)
+			}
+		})
+	}
+}
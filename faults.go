@@ -0,0 +1,34 @@
+package main
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+var (
+	fakeLossOnce sync.Once
+	fakeLossMu   sync.Mutex
+	fakeLossRand *rand.Rand
+)
+
+// fakeLoss reports whether a matched reply should be discarded as if it had
+// been lost, according to --fake-loss. This exists only to exercise
+// quickping's own loss handling and must not be used for real measurements.
+func fakeLoss() bool {
+	if *flagFakeLoss <= 0 {
+		return false
+	}
+
+	fakeLossOnce.Do(func() {
+		seed := *flagSeed
+		if seed == 0 {
+			seed = time.Now().UnixNano()
+		}
+		fakeLossRand = rand.New(rand.NewSource(seed))
+	})
+
+	fakeLossMu.Lock()
+	defer fakeLossMu.Unlock()
+	return fakeLossRand.Float64()*100 < *flagFakeLoss
+}
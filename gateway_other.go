@@ -0,0 +1,13 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"errors"
+	"net"
+)
+
+func defaultGateway() (net.IP, error) {
+	return nil, errors.New("cannot find the default gateway on this platform; use --gateway")
+}
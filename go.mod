@@ -5,6 +5,7 @@ go 1.17
 require (
 	github.com/spf13/pflag v1.0.5
 	golang.org/x/net v0.23.0
+	golang.org/x/term v0.18.0
 )
 
 require golang.org/x/sys v0.18.0 // indirect
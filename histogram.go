@@ -0,0 +1,60 @@
+package main
+
+import (
+	"log"
+	"strings"
+	"time"
+)
+
+// histogramWidth is the length of the longest bar drawn by printHistogram.
+const histogramWidth = 40
+
+// maxHistogramBuckets bounds the number of lines printHistogram prints.
+const maxHistogramBuckets = 200
+
+// printHistogram logs an ASCII histogram of the RTTs of every reply, with
+// buckets of the given width, for --histogram-buckets.
+func printHistogram(hosts []*hostResults, width time.Duration) {
+	var rtts []time.Duration
+	for _, h := range hosts {
+		for _, r := range h.results {
+			if r.Status == statusReply {
+				rtts = append(rtts, r.RTT)
+			}
+		}
+	}
+	if len(rtts) == 0 {
+		return
+	}
+
+	first, last := rtts[0]/width, rtts[0]/width
+	for _, rtt := range rtts {
+		b := rtt / width
+		if b < first {
+			first = b
+		}
+		if b > last {
+			last = b
+		}
+	}
+	if last-first+1 > maxHistogramBuckets {
+		log.Printf("RTT histogram: %s buckets would need %d lines; use a wider --histogram-buckets", formatRTT(width), last-first+1)
+		return
+	}
+	counts := make([]int, last-first+1)
+	var most int
+	for _, rtt := range rtts {
+		i := rtt/width - first
+		counts[i]++
+		if counts[i] > most {
+			most = counts[i]
+		}
+	}
+
+	log.Printf("RTT histogram (%d replies):", len(rtts))
+	for i, n := range counts {
+		lo := (first + time.Duration(i)) * width
+		bar := strings.Repeat("#", (n*histogramWidth+most-1)/most)
+		log.Printf("  %10s - %-10s |%-*s %d", formatRTT(lo), formatRTT(lo+width), histogramWidth, bar, n)
+	}
+}
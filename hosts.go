@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+)
+
+var (
+	hostsOnce sync.Once
+	hostsMap  map[string][]string
+	hostsErr  error
+)
+
+// Resolver looks up the addresses of a host. *net.Resolver satisfies it.
+type Resolver interface {
+	LookupHost(ctx context.Context, host string) ([]string, error)
+}
+
+// resolver is the Resolver used to look up targets.
+var resolver Resolver = net.DefaultResolver
+
+// hostsFileResolver resolves names using only a hosts file, ignoring the
+// system resolver entirely. The file is read on first use.
+type hostsFileResolver struct {
+	path string
+}
+
+// LookupHost implements Resolver. Literal IP addresses are returned as-is.
+func (r hostsFileResolver) LookupHost(ctx context.Context, name string) ([]string, error) {
+	if net.ParseIP(name) != nil {
+		return []string{name}, nil
+	}
+
+	hostsOnce.Do(func() {
+		hostsMap, hostsErr = parseHostsFile(r.path)
+	})
+	if hostsErr != nil {
+		return nil, hostsErr
+	}
+
+	addrs, ok := hostsMap[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("%q not found in %s", name, r.path)
+	}
+	return addrs, nil
+}
+
+// parseHostsFile reads a hosts(5) file and returns a map from lower-cased
+// host name to the addresses listed for it, in file order.
+func parseHostsFile(path string) (map[string][]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	hosts := make(map[string][]string)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 || net.ParseIP(fields[0]) == nil {
+			continue
+		}
+		for _, name := range fields[1:] {
+			name = strings.ToLower(name)
+			hosts[name] = append(hosts[name], fields[0])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading %s: %v", path, err)
+	}
+	return hosts, nil
+}
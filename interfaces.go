@@ -0,0 +1,210 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// localSource is a local address that requests can be sent from.
+type localSource struct {
+	iface    string // label for results sent from it
+	ip       net.IP
+	device   string // interface to bind to, if any
+	loopback bool   // on a loopback interface, so only useful for loopback targets
+}
+
+// sourceSet holds the parsed --source-set addresses, each labelled with
+// the address itself.
+var sourceSet []localSource
+
+// parseSourceSet parses the --source-set addresses, checking that each is
+// assigned to a local interface.
+func parseSourceSet(addrs []string) ([]localSource, error) {
+	local := make(map[string]bool)
+	ifaddrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range ifaddrs {
+		if ipn, ok := a.(*net.IPNet); ok {
+			local[ipn.IP.String()] = true
+		}
+	}
+
+	var sources []localSource
+	for _, a := range addrs {
+		ip := net.ParseIP(a)
+		if ip == nil {
+			return nil, fmt.Errorf("%q is not an IP address", a)
+		}
+		if !local[ip.String()] {
+			return nil, fmt.Errorf("%s is not a local address", ip)
+		}
+		sources = append(sources, localSource{iface: ip.String(), ip: ip})
+	}
+	return sources, nil
+}
+
+// interfaceSources returns one address per family for every interface that
+// is up, for --listen-all-interfaces. Link-local IPv6 addresses are skipped,
+// since they can only reach targets on the same link, and loopback
+// interfaces are marked, since they can only reach loopback targets.
+//
+// Where canBindDevice, each source is bound to its interface, so requests
+// really leave through it. Elsewhere only the source address is set, which
+// routing may still send out of another interface, so results are labelled
+// with the address rather than the interface name.
+func interfaceSources() ([]localSource, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+
+	var sources []localSource
+	for _, ifi := range ifaces {
+		if ifi.Flags&net.FlagUp == 0 {
+			continue
+		}
+		addrs, err := ifi.Addrs()
+		if err != nil {
+			return nil, err
+		}
+
+		var have4, have6 bool
+		for _, a := range addrs {
+			ipn, ok := a.(*net.IPNet)
+			if !ok || ipn.IP.IsLinkLocalUnicast() {
+				continue
+			}
+			if ipn.IP.To4() != nil {
+				if have4 {
+					continue
+				}
+				have4 = true
+			} else {
+				if have6 {
+					continue
+				}
+				have6 = true
+			}
+			src := localSource{iface: ipn.IP.String(), ip: ipn.IP, loopback: ifi.Flags&net.FlagLoopback != 0}
+			if canBindDevice {
+				src.iface, src.device = ifi.Name, ifi.Name
+			}
+			sources = append(sources, src)
+		}
+	}
+	return sources, nil
+}
+
+// routeSource returns the local address the kernel would use as the source
+// for packets to ip, by connecting a UDP socket to it. Connecting a UDP
+// socket sends nothing on the wire.
+func routeSource(ip net.IP) (*net.IPAddr, error) {
+	c, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: ip, Port: 9})
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	la := c.LocalAddr().(*net.UDPAddr)
+	return &net.IPAddr{IP: la.IP, Zone: la.Zone}, nil
+}
+
+// routeInterface returns the interface that packets to ip would be sent
+// from, or nil if the source address is not on any interface.
+func routeInterface(ip net.IP) (*net.Interface, error) {
+	src, err := routeSource(ip)
+	if err != nil {
+		return nil, err
+	}
+	return interfaceWithAddr(src.IP)
+}
+
+// interfaceWithAddr returns the interface that ip is assigned to, or nil if
+// there is none.
+func interfaceWithAddr(ip net.IP) (*net.Interface, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	for i := range ifaces {
+		addrs, err := ifaces[i].Addrs()
+		if err != nil {
+			continue
+		}
+		for _, a := range addrs {
+			if ipn, ok := a.(*net.IPNet); ok && ipn.IP.Equal(ip) {
+				return &ifaces[i], nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+// listInterfaces prints every network interface with its status and
+// addresses, for --list-interfaces.
+func listInterfaces() error {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return err
+	}
+	for _, ifi := range ifaces {
+		status := "down"
+		if ifi.Flags&net.FlagUp != 0 {
+			status = "up"
+		}
+		var addrs []string
+		if as, err := ifi.Addrs(); err == nil {
+			for _, a := range as {
+				addrs = append(addrs, a.String())
+			}
+		}
+		fmt.Printf("%s\t%s\tmtu=%d\t%s\n", ifi.Name, status, ifi.MTU, strings.Join(addrs, " "))
+	}
+	return nil
+}
+
+// interfaceNames returns the names of every network interface.
+func interfaceNames() []string {
+	ifaces, _ := net.Interfaces()
+	names := make([]string, len(ifaces))
+	for i, ifi := range ifaces {
+		names[i] = ifi.Name
+	}
+	return names
+}
+
+// egressUnknown is the result.Egress of a request whose egress interface
+// could not be determined.
+const egressUnknown = "unknown"
+
+// egressInterface returns the name of the interface that a request to ip
+// from the local address listen leaves through. It is found from the
+// source address: listen itself if it is not a wildcard, or else the one
+// routing picks for ip.
+func egressInterface(ip net.IP, listen string) (string, error) {
+	if ip.To4() == nil && v6Interface != nil {
+		return v6Interface.Name, nil
+	}
+	src := net.ParseIP(listen)
+	if src == nil || src.IsUnspecified() {
+		if *flagRoutingTable != 0 {
+			return "", fmt.Errorf("the route lookup does not follow --routing-table")
+		}
+		a, err := routeSource(ip)
+		if err != nil {
+			return "", err
+		}
+		src = a.IP
+	}
+	ifi, err := interfaceWithAddr(src)
+	if err != nil {
+		return "", err
+	}
+	if ifi == nil {
+		return "", fmt.Errorf("source address %s is not on any interface", src)
+	}
+	return ifi.Name, nil
+}
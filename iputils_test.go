@@ -0,0 +1,28 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+	"time"
+)
+
+// TestIPutilsPayload compares iputilsPayload with golden echo data laid
+// out as iputils ping on 64-bit Linux sends it at 1700000000.123456:
+// tv_sec and tv_usec as little-endian 8-byte integers, then bytes 0x10 to
+// 0x37. Sub-microsecond time is truncated, as by gettimeofday.
+func TestIPutilsPayload(t *testing.T) {
+	want, err := hex.DecodeString("" +
+		"00f1536500000000" + // tv_sec 1700000000
+		"40e2010000000000" + // tv_usec 123456
+		"101112131415161718191a1b1c1d1e1f" +
+		"202122232425262728292a2b2c2d2e2f" +
+		"3031323334353637")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := iputilsPayload(time.Unix(1700000000, 123456789))
+	if !bytes.Equal(got, want) {
+		t.Errorf("got\n%x\nwant\n%x", got, want)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// latencyClass is one --latency-classes band: replies with an RTT below max
+// are labelled name. The last band has no max and takes every other reply.
+type latencyClass struct {
+	name string
+	max  time.Duration
+}
+
+// latencyClasses are the parsed --latency-classes bands, in ascending order.
+var latencyClasses []latencyClass
+
+// parseLatencyClasses parses a --latency-classes value such as
+// "good=50ms,fair=150ms,poor": bands with strictly ascending upper bounds,
+// followed by a final catch-all band without one.
+func parseLatencyClasses(s string) ([]latencyClass, error) {
+	var classes []latencyClass
+	seen := make(map[string]bool)
+	parts := strings.Split(s, ",")
+	for i, part := range parts {
+		name, bound, hasBound := strings.TrimSpace(part), "", false
+		if j := strings.Index(name, "="); j >= 0 {
+			name, bound, hasBound = name[:j], name[j+1:], true
+		}
+		if name == "" {
+			return nil, fmt.Errorf("empty band name in %q", part)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("duplicate band %q", name)
+		}
+		seen[name] = true
+
+		last := i == len(parts)-1
+		if last != !hasBound {
+			if last {
+				return nil, fmt.Errorf("last band %q must not have an upper bound", name)
+			}
+			return nil, fmt.Errorf("band %q needs an upper bound", name)
+		}
+		c := latencyClass{name: name}
+		if hasBound {
+			d, err := time.ParseDuration(bound)
+			if err != nil {
+				return nil, fmt.Errorf("band %q: %v", name, err)
+			}
+			if len(classes) > 0 && d <= classes[len(classes)-1].max {
+				return nil, fmt.Errorf("band %q: bound %s is not above the previous band's", name, d)
+			}
+			c.max = d
+		}
+		classes = append(classes, c)
+	}
+	return classes, nil
+}
+
+// classify returns the --latency-classes band of r, or "" if r is not a
+// reply or no bands were given.
+func classify(r result) string {
+	if r.Status != statusReply || len(latencyClasses) == 0 {
+		return ""
+	}
+	for _, c := range latencyClasses[:len(latencyClasses)-1] {
+		if r.RTT < c.max {
+			return c.name
+		}
+	}
+	return latencyClasses[len(latencyClasses)-1].name
+}
+
+// logClassCounts logs how many replies fell into each --latency-classes
+// band over the run.
+func logClassCounts(hosts []*hostResults) {
+	counts := make(map[string]int)
+	for _, h := range hosts {
+		for _, r := range h.results {
+			if r.Class != "" {
+				counts[r.Class]++
+			}
+		}
+	}
+	var parts []string
+	for _, c := range latencyClasses {
+		parts = append(parts, fmt.Sprintf("%s=%d", c.name, counts[c.name]))
+	}
+	log.Printf("latency classes: %s", strings.Join(parts, " "))
+}
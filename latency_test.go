@@ -0,0 +1,54 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseLatencyClasses(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    []latencyClass
+		wantErr bool
+	}{
+		{in: "good=50ms,fair=150ms,poor", want: []latencyClass{{"good", 50 * time.Millisecond}, {"fair", 150 * time.Millisecond}, {"poor", 0}}},
+		{in: "all", want: []latencyClass{{"all", 0}}},
+		{in: " fast=1ms , slow ", want: []latencyClass{{"fast", time.Millisecond}, {"slow", 0}}},
+		{in: "good,poor", wantErr: true},
+		{in: "good=50ms,poor=1s", wantErr: true},
+		{in: "a=2ms,b=1ms,c", wantErr: true},
+		{in: "a=1ms,a", wantErr: true},
+		{in: "=1ms,poor", wantErr: true},
+		{in: "good=fast,poor", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseLatencyClasses(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseLatencyClasses(%q): got error %v; want error %t", tt.in, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseLatencyClasses(%q) = %v; want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestClassify(t *testing.T) {
+	defer func(c []latencyClass) { latencyClasses = c }(latencyClasses)
+	latencyClasses = []latencyClass{{"good", 50 * time.Millisecond}, {"poor", 0}}
+
+	for _, tt := range []struct {
+		status string
+		rtt    time.Duration
+		want   string
+	}{
+		{statusReply, 49 * time.Millisecond, "good"},
+		{statusReply, 50 * time.Millisecond, "poor"},
+		{statusTimeout, time.Millisecond, ""},
+	} {
+		if got := classify(result{Status: tt.status, RTT: tt.rtt}); got != tt.want {
+			t.Errorf("classify(%s in %s) = %q; want %q", tt.status, tt.rtt, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"syscall"
+)
+
+var errRoutingTableUnsupported = errors.New("--routing-table needs a raw IP socket")
+
+// setRoutingMark sets SO_MARK on c, so that an "ip rule add fwmark <mark>
+// table <mark>" policy routes its packets through that table.
+func setRoutingMark(c net.PacketConn, mark int) error {
+	ipc, ok := c.(*net.IPConn)
+	if !ok {
+		return errRoutingTableUnsupported
+	}
+	rc, err := ipc.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var serr error
+	err = rc.Control(func(fd uintptr) {
+		serr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_MARK, mark)
+	})
+	if err != nil {
+		return err
+	}
+	return serr
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"errors"
+	"net"
+)
+
+var errRoutingTableUnsupported = errors.New("--routing-table is only supported on Linux")
+
+func setRoutingMark(c net.PacketConn, mark int) error {
+	return errRoutingTableUnsupported
+}
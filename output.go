@@ -0,0 +1,318 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"golang.org/x/net/icmp"
+)
+
+// Possible values for result.Status.
+const (
+	statusReply        = "reply"
+	statusTimeout      = "timeout"
+	statusUnreachable  = "unreachable"
+	statusTimeExceeded = "time-exceeded"
+	statusOther        = "other"     // an unexpected ICMP type, with --strict-reply-type=warn
+	statusTruncated    = "truncated" // a reply too large for --recv-buffer-size to parse
+	statusSent         = "sent"      // the request was sent, with --send-only
+)
+
+// Possible prefixes of result.Reason.
+const (
+	reasonNoReply    = "no-reply"    // the request was sent, but nothing came back
+	reasonSendFailed = "send-failed" // the kernel refused to send the request
+)
+
+// result is the outcome of pinging a single resolved address.
+type result struct {
+	Host   string        // target as given on the command line
+	IP     net.IP        // resolved address that was pinged
+	ID     int           // ICMP identifier of the request
+	Seq    int           // ICMP sequence number of the request
+	RTT    time.Duration // time until the reply, or until giving up
+	TTL    int           // TTL or hop limit of the reply, or 0 if unknown
+	Src    net.IP        // local address the reply arrived on, if known
+	Peer   net.IP        // address the echo reply came from
+	Iface  string        // interface (or address, with --source-set or where interfaces cannot be bound) the request was sent from
+	Tag    string        // free-form --tag value
+	Status string        // one of the status* constants
+	Reason string        // probable cause of a timeout, starting with a reason* constant
+	Quoted bool          // an ICMP error was matched to the request by the datagram it quoted
+	Class  string        // --latency-classes band of a reply, if any
+	Label  string        // --label-from-ptr name of a replying address, if any
+	Egress string        // interface the request left through, if looked up, or egressUnknown
+
+	// TTLBelowMin is set if the reply's TTL was below --min-ttl.
+	TTLBelowMin bool
+
+	// SentBytes and ReceivedBytes are the sizes of the ICMP request, if it
+	// was sent, and of its echo reply, if any.
+	SentBytes, ReceivedBytes int
+
+	// IDRewritten is set if the reply, matched by --match-nonce, carried
+	// ICMP ID ReplyID instead of the one sent.
+	IDRewritten bool
+	ReplyID     int
+
+	// MPLS holds any MPLS label stack entries carried by an ICMP error.
+	MPLS []icmp.MPLSLabel
+}
+
+// responded reports whether r shows the host answering: a reply, or with
+// --treat-unreachable-as-down=false, a Destination Unreachable.
+func (r result) responded() bool {
+	return r.Status == statusReply || (r.Status == statusUnreachable && !*flagUnreachableDown)
+}
+
+// outputTemplate is the parsed --format template, if any.
+var outputTemplate *template.Template
+
+// humanOutput reports whether results are being logged as human-readable
+// lines as they arrive, rather than sent only to syslog, printed in a
+// machine-readable format, or held back for --only-alive or --only-dead.
+func humanOutput() bool {
+	return !*flagSyslogOnly && outputTemplate == nil && !*flagInflux && !*flagOnlyAlive && !*flagOnlyDead
+}
+
+// report prints r in the output format selected on the command line, and
+// sends it to syslog with --syslog.
+func report(r result) {
+	sendSyslog(r)
+	if *flagSyslogOnly {
+		return
+	}
+	if outputTemplate != nil {
+		if err := outputTemplate.Execute(stdout, r); err != nil {
+			log.Printf("[%s] error formatting result: %v", r.Host, err)
+		}
+		return
+	}
+	if *flagInflux {
+		fmt.Fprintln(stdout, formatInflux(r, clock.Now()))
+		return
+	}
+
+	// Only responders are worth the latency of a reverse lookup.
+	if *flagReverse && r.Status == statusReply {
+		lookupReverse([]string{r.Host})
+	}
+
+	// where follows the address on every result line.
+	where := ""
+	if r.Iface != "" {
+		where = " via " + r.Iface
+	}
+	if *flagShowIDSeq {
+		where += fmt.Sprintf(" id=%#04x seq=%d", r.ID, r.Seq)
+	}
+	if r.Class != "" {
+		where += " class=" + r.Class
+	}
+	switch r.Status {
+	case statusReply:
+		if *flagInferHops && r.TTL > 0 {
+			initial, hops := inferHops(r.TTL)
+			log.Printf("[%s] %s%s: got reply in %s (ttl=%d, hops≈%d from initial ttl %d)", r.displayName(), r.IP, where, formatRTT(r.RTT), r.TTL, hops, initial)
+			break
+		}
+		log.Printf("[%s] %s%s: got reply in %s", r.displayName(), r.IP, where, formatRTT(r.RTT))
+	case statusTimeout:
+		if strings.HasPrefix(r.Reason, reasonSendFailed) {
+			log.Printf("[%s] %s%s: request not sent (%s)", r.displayName(), r.IP, where, r.Reason)
+			break
+		}
+		log.Printf("[%s] %s%s: request timeout in %s", r.displayName(), r.IP, where, formatRTT(r.RTT))
+	case statusUnreachable, statusTimeExceeded:
+		what := "destination unreachable"
+		if r.Status == statusTimeExceeded {
+			what = "time exceeded"
+		}
+		if r.Quoted {
+			what = fmt.Sprintf("seq %d: %s (from the quoted packet)", r.Seq, what)
+		}
+		log.Printf("[%s] %s%s: %s in %s", r.displayName(), r.IP, where, what, formatRTT(r.RTT))
+	case statusTruncated:
+		log.Printf("[%s] %s%s: truncated reply in %s", r.displayName(), r.IP, where, formatRTT(r.RTT))
+	case statusSent:
+		log.Printf("[%s] %s%s: request sent", r.displayName(), r.IP, where)
+	case statusOther:
+		log.Printf("[%s] %s%s: unexpected reply in %s", r.displayName(), r.IP, where, formatRTT(r.RTT))
+	}
+	if *flagVerbose && r.Src != nil {
+		log.Printf("[%s] %s: local address %s", r.Host, r.IP, r.Src)
+	}
+	if *flagVerbose && r.Egress != "" && r.Egress != egressUnknown {
+		log.Printf("[%s] %s: egress interface %s", r.Host, r.IP, r.Egress)
+	}
+	for _, l := range r.MPLS {
+		log.Printf("[%s] %s: mpls label=%d tc=%d s=%t ttl=%d", r.Host, r.IP, l.Label, l.TC, l.S, l.TTL)
+	}
+}
+
+// syslogLine formats r as a single key=value line for syslog.
+func syslogLine(r result) string {
+	line := fmt.Sprintf("host=%s ip=%s status=%s", r.Host, r.IP, r.Status)
+	if r.Status == statusReply {
+		line += fmt.Sprintf(" rtt=%s ttl=%d", formatRTT(r.RTT), r.TTL)
+	}
+	if r.Class != "" {
+		line += " class=" + r.Class
+	}
+	if r.Label != "" {
+		line += fmt.Sprintf(" label=%q", r.Label)
+	}
+	if r.Reason != "" {
+		line += fmt.Sprintf(" reason=%q", r.Reason)
+	}
+	if r.Tag != "" {
+		line += fmt.Sprintf(" tag=%q", r.Tag)
+	}
+	return line
+}
+
+// dumpRaw logs a hex dump of the ICMP message b, sent or received while
+// handling req, for --dump-raw.
+func dumpRaw(direction string, req *request, b []byte) {
+	log.Printf("[%s] %s: %s %d bytes (seq=%d):\n%s", req.addr, req.resolved.IP, direction, len(b), req.seq, strings.TrimSuffix(hex.Dump(b), "\n"))
+}
+
+// influxTagEscaper escapes the characters that are special in InfluxDB
+// line protocol tag keys and values.
+var influxTagEscaper = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+
+// formatInflux formats r as an InfluxDB line protocol point. Since each
+// address is pinged once, a single point carries both the RTT and the loss
+// for that address.
+func formatInflux(r result, now time.Time) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "ping,host=%s,ip=%s", influxTagEscaper.Replace(r.Host), influxTagEscaper.Replace(r.IP.String()))
+	if r.Iface != "" {
+		fmt.Fprintf(&sb, ",iface=%s", influxTagEscaper.Replace(r.Iface))
+	}
+	if r.Tag != "" {
+		fmt.Fprintf(&sb, ",tag=%s", influxTagEscaper.Replace(r.Tag))
+	}
+	if r.Class != "" {
+		fmt.Fprintf(&sb, ",class=%s", influxTagEscaper.Replace(r.Class))
+	}
+	if r.Label != "" {
+		fmt.Fprintf(&sb, ",label=%s", influxTagEscaper.Replace(r.Label))
+	}
+	fmt.Fprintf(&sb, " status=%q", r.Status)
+	if *flagShowIDSeq {
+		fmt.Fprintf(&sb, ",id=%di,seq=%di", r.ID, r.Seq)
+	}
+	if r.Reason != "" {
+		fmt.Fprintf(&sb, ",reason=%q", r.Reason)
+	}
+	if r.Status == statusReply {
+		fmt.Fprintf(&sb, ",rtt=%s,loss=0", influxSeconds(r.RTT))
+	} else if r.responded() {
+		sb.WriteString(",loss=0")
+	} else if r.Status != statusSent {
+		sb.WriteString(",loss=1")
+	}
+	fmt.Fprintf(&sb, " %d", now.UnixNano())
+	return sb.String()
+}
+
+// formatInfluxSummary formats the results of h as a single ping_summary
+// point, counting losses and RTTs as --summary-json-file does, or returns
+// "" if h has no results.
+func formatInfluxSummary(h *hostResults, now time.Time) string {
+	var sent, received, unreachable int
+	var min, max, total time.Duration
+	for _, r := range h.results {
+		sent++
+		switch r.Status {
+		case statusReply:
+			if received == 0 || r.RTT < min {
+				min = r.RTT
+			}
+			if received == 0 || r.RTT > max {
+				max = r.RTT
+			}
+			total += r.RTT
+			received++
+		case statusUnreachable:
+			unreachable++
+		}
+	}
+	if sent == 0 {
+		return ""
+	}
+	lost := sent - received
+	if !*flagUnreachableDown {
+		lost -= unreachable
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "ping_summary,host=%s sent=%di,received=%di,loss=%s", influxTagEscaper.Replace(h.host), sent, received, strconv.FormatFloat(float64(lost)/float64(sent), 'f', -1, 64))
+	if received > 0 {
+		avg := total / time.Duration(received)
+		fmt.Fprintf(&sb, ",rtt_min=%s,rtt_avg=%s,rtt_max=%s", influxSeconds(min), influxSeconds(avg), influxSeconds(max))
+	}
+	fmt.Fprintf(&sb, " %d", now.UnixNano())
+	return sb.String()
+}
+
+// influxSeconds formats d as an InfluxDB float field in seconds.
+func influxSeconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', -1, 64)
+}
+
+// commonInitialTTLs are the initial TTLs used by most IP stacks.
+var commonInitialTTLs = []int{64, 128, 255}
+
+// inferHops guesses the initial TTL a reply was sent with, as the smallest
+// common initial TTL not below the received one, and returns it along with
+// the number of hops the reply apparently travelled.
+func inferHops(ttl int) (initial, hops int) {
+	for _, t := range commonInitialTTLs {
+		if ttl <= t {
+			return t, t - ttl
+		}
+	}
+	return ttl, 0
+}
+
+// rttUnits are the valid --rtt-unit values, other than "".
+var rttUnits = map[string]bool{"ms": true, "us": true, "ns": true, "auto": true}
+
+// formatRTT formats an RTT for human-readable output in the unit chosen by
+// --rtt-unit. By default Go's duration formatting is used; "auto" picks
+// microseconds below 1ms, milliseconds below 1s, and seconds otherwise,
+// always with a fixed precision.
+func formatRTT(d time.Duration) string {
+	switch *flagRTTUnit {
+	case "ms":
+		return fmt.Sprintf("%.3fms", float64(d)/float64(time.Millisecond))
+	case "us":
+		return fmt.Sprintf("%.1fus", float64(d)/float64(time.Microsecond))
+	case "ns":
+		return fmt.Sprintf("%dns", d.Nanoseconds())
+	case "auto":
+		switch {
+		case d < time.Millisecond:
+			return fmt.Sprintf("%.1fus", float64(d)/float64(time.Microsecond))
+		case d < time.Second:
+			return fmt.Sprintf("%.3fms", float64(d)/float64(time.Millisecond))
+		default:
+			return fmt.Sprintf("%.3fs", d.Seconds())
+		}
+	}
+	return d.String()
+}
+
+// FormattedRTT returns the RTT formatted according to --rtt-unit, for use in
+// --format templates.
+func (r result) FormattedRTT() string {
+	return formatRTT(r.RTT)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// progressInterval is how often --progress redraws its status line.
+const progressInterval = time.Second
+
+// progress tracks how many hosts have finished, for --progress.
+type progress struct {
+	total   int
+	done    int64
+	stop    chan struct{}
+	stopped chan struct{} // nil if no progress line is being drawn
+}
+
+// startProgress starts redrawing a progress line on stderr, unless stderr
+// is not a terminal, the output is meant for machines, or --output-buffered
+// would hold log lines back while the progress line is redrawn under them.
+func startProgress(total int) *progress {
+	p := &progress{total: total, stop: make(chan struct{})}
+	if *flagInflux || *flagFormat != "" || *flagOutputBuffered || !term.IsTerminal(int(os.Stderr.Fd())) {
+		return p
+	}
+
+	// Clear the progress line before each log line, so the two don't run
+	// together; the next tick redraws it.
+	lw := &clearLineWriter{w: log.Writer()}
+	log.SetOutput(lw)
+
+	p.stopped = make(chan struct{})
+	go func() {
+		defer close(p.stopped)
+		t := clock.NewTicker(progressInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C():
+				lw.mu.Lock()
+				fmt.Fprintf(os.Stderr, "\rpinged %d/%d hosts...\x1b[K", atomic.LoadInt64(&p.done), p.total)
+				lw.mu.Unlock()
+			case <-p.stop:
+				lw.mu.Lock()
+				fmt.Fprint(os.Stderr, "\r\x1b[K")
+				lw.mu.Unlock()
+				return
+			}
+		}
+	}()
+	return p
+}
+
+// hostDone records that one more host has finished.
+func (p *progress) hostDone() {
+	atomic.AddInt64(&p.done, 1)
+}
+
+// finish stops redrawing and clears the progress line.
+func (p *progress) finish() {
+	close(p.stop)
+	if p.stopped != nil {
+		<-p.stopped
+	}
+}
+
+// clearLineWriter clears the current terminal line before every write.
+type clearLineWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (c *clearLineWriter) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, err := io.WriteString(c.w, "\r\x1b[K"); err != nil {
+		return 0, err
+	}
+	return c.w.Write(p)
+}
@@ -1,11 +1,16 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"hash/fnv"
 	"log"
+	"math"
 	"net"
 	"os"
+	"strings"
 	"sync"
+	"text/template"
 	"time"
 
 	flag "github.com/spf13/pflag"
@@ -20,191 +25,1108 @@ const (
 )
 
 var (
-	flagTimeout = flag.DurationP("timeout", "t", 5*time.Second, "time to wait for a reply")
-	flagListen4 = flag.String("listen4", "0.0.0.0", "listen address for IPv4 sockets")
-	flagListen6 = flag.String("listen6", "::", "listen address for IPv6 sockets")
-	flagData    = flag.BytesHexP("data", "d", []byte{}, "data to send in the request, as hex bytes")
+	flagTimeout             = flag.DurationP("timeout", "t", 5*time.Second, "time to wait for a reply")
+	flagListen4             = flag.String("listen4", "0.0.0.0", "listen address for IPv4 sockets")
+	flagListen6             = flag.String("listen6", "::", "listen address for IPv6 sockets")
+	flagData                = flag.BytesHexP("data", "d", []byte{}, "data to send in the request, as hex bytes")
+	flagRecvBuf             = flag.Int("recv-buffer-size", 1500, "size of the buffer used to read replies, in bytes (max 65535)")
+	flagNoResolve           = flag.Bool("no-resolve", false, "require every target to be a literal IP address and never perform DNS lookups")
+	flagDryRun              = flag.Bool("dry-run", false, "resolve targets and print what would be pinged, without sending")
+	flagInflux              = flag.Bool("influx", false, "print results, then a summary per host, in InfluxDB line protocol")
+	flagProbeBoth           = flag.Bool("probe-both-and-report-winner", false, "ping the first IPv4 and IPv6 address of each host at once and report which family replied first")
+	flagV6Interface         = flag.String("v6-interface", "", "outgoing interface for IPv6 packets, required for many link-local and multicast targets")
+	flagFormat              = flag.String("format", "", "Go text/template used to print each result, e.g. '{{.Host}} {{.IP}} {{.RTT}}'")
+	flagFailFast            = flag.Bool("fail-fast", false, "exit with a non-zero status on the first destination unreachable reply")
+	flagFailFastTimeout     = flag.Bool("fail-fast-timeout", false, "with --fail-fast, also exit on the first timeout")
+	flagMatchNonce          = flag.Bool("match-nonce", false, "append a random 8-byte nonce to the payload and match replies on it instead of ID and sequence")
+	flagPrintConfig         = flag.Bool("print-config", false, "print the effective value of every option as JSON and exit")
+	flagVerbose             = flag.BoolP("verbose", "v", false, "print additional diagnostic information")
+	flagAllInterfaces       = flag.Bool("listen-all-interfaces", false, "ping each target once from every local interface")
+	flagFakeLoss            = flag.Float64("fake-loss", 0, "percentage of replies to discard as if lost (testing only)")
+	flagSeed                = flag.Int64("seed", 0, "random seed for --fake-loss; 0 picks one from the current time")
+	flagInferHops           = flag.Bool("infer-hops", false, "print the reply TTL and the hop count inferred from it")
+	flagHostsFileOnly       = flag.Bool("hosts-file-only", false, "resolve names only from the hosts file, never via DNS")
+	flagHostsFile           = flag.String("hosts-file", "/etc/hosts", "hosts file used by --hosts-file-only")
+	flagSelfTest            = flag.Bool("selftest", false, "ping the loopback addresses to check that quickping can open raw sockets, send and receive")
+	flagBindSourcePerTarget = flag.Bool("bind-source-per-target", false, "bind each socket to the source address the routing table picks for its target")
+	flagFirstReplyWins      = flag.Bool("first-reply-wins", false, "ping all addresses of each host at once and report only the first to reply")
+	flagOutputBuffered      = flag.Bool("output-buffered", false, "buffer output and flush it periodically, for high result rates")
+	flagTag                 = flag.String("tag", "", "free-form tag included in machine-readable output, e.g. region=us-east")
+	flagProgress            = flag.Bool("progress", false, "show how many hosts have been pinged so far on stderr, when it is a terminal")
+	flagOnlyAlive           = flag.Bool("only-alive", false, "print results only for hosts that replied, once all hosts are done")
+	flagOnlyDead            = flag.Bool("only-dead", false, "print results only for hosts that did not reply, once all hosts are done")
+	flagKernelTimestamps    = flag.Bool("kernel-timestamps", false, "measure RTT using kernel receive timestamps (Linux only)")
+	flagReverse             = flag.Bool("reverse", false, "label literal IP targets with their reverse DNS name in output")
+	flagTargetsURL          = flag.String("targets-url", "", "fetch additional targets from this URL, as a JSON array or one host per line")
+	flagTargetsURLInsecure  = flag.Bool("targets-url-insecure", false, "skip TLS certificate verification for --targets-url")
+	flagRTTUnit             = flag.String("rtt-unit", "", "unit for RTTs in human-readable output: ms, us, ns or auto (default: Go duration format)")
+	flagPayloadFile         = flag.String("payload-file", "", "send the contents of this file as the request data, instead of --data")
+	flagSummaryJSONFile     = flag.String("summary-json-file", "", "write a JSON summary of every address pinged to this file")
+	flagSpoofSource         = flag.String("spoof-source", "", "send IPv4 requests with this source address, without waiting for replies (requires --i-know-what-im-doing)")
+	flagIKnowWhatImDoing    = flag.Bool("i-know-what-im-doing", false, "allow options that send packets which cannot be answered, like --spoof-source")
+	flagExcludeLoopback     = flag.Bool("exclude-loopback", false, "skip loopback and link-local addresses")
+	flagExcludePrivate      = flag.Bool("exclude-private", false, "skip private (RFC 1918 and IPv6 ULA) addresses")
+	flagStrictReplyType     = flag.String("strict-reply-type", "warn", "how to handle unexpected ICMP reply types: error, warn (report the address as \"other\") or loss (ignore them)")
+	flagQuietErrors         = flag.Bool("quiet-errors", false, "show each distinct error once, and periodically how many times it repeated")
+	flagWarmup              = flag.Int("warmup", 0, "send this many unreported requests to each address before the measured one, so ARP/ND and route setup do not skew its RTT")
+	flagConfig              = flag.String("config", "", "read options and targets from this TOML file; command-line flags and environment variables take precedence")
+	flagRoutingTable        = flag.Int("routing-table", 0, "mark requests with this fwmark, for an \"ip rule add fwmark N table N\" policy (Linux only)")
+	flagDumpRaw             = flag.Bool("dump-raw", false, "print a hex dump of every ICMP message sent and received")
+	flagRequireAllUp        = flag.Bool("require-all-up", false, "exit with status 1, listing the hosts that never replied, unless every host replied")
+	flagListInterfaces      = flag.Bool("list-interfaces", false, "list network interfaces with their addresses and status, and exit")
+	flagHistogramBuckets    = flag.Duration("histogram-buckets", 0, "after the run, print a histogram of reply RTTs with buckets of this width")
+	flagSyslog              = flag.Bool("syslog", false, "also send results to syslog: replies at info, unreachable at err, anything else at warning")
+	flagSyslogAddr          = flag.String("syslog-addr", "", "syslog daemon to send to, as network://host:port (default: the local daemon)")
+	flagSyslogFacility      = flag.String("syslog-facility", "user", "syslog facility for --syslog")
+	flagSyslogOnly          = flag.Bool("syslog-only", false, "with --syslog, send results only to syslog")
+	flagShowIDSeq           = flag.Bool("show-id-seq", false, "include the ICMP identifier and sequence number of the request in every result")
+	flagSendOnly            = flag.Bool("send-only", false, "send the requests without waiting for replies; no RTT can be measured")
+	flagReceiveOnly         = flag.Bool("receive-only", false, "send nothing, and log every echo request and reply received for --timeout")
+	flagIPv6ExtHeader       = flag.String("ipv6-ext-header", "", "add an empty IPv6 extension header to requests, hop-by-hop or dst-opts, to test whether the path drops them (Linux only)")
+	flagIDPerHost           = flag.Bool("id-per-host", false, "use an ICMP identifier derived from a hash of each target, instead of one per process")
+	flagReportTemplate      = flag.String("report-template", "", "after the run, render this text/template file against every result and summary")
+	flagProbeGatewayFirst   = flag.Bool("probe-gateway-first", false, "ping the default gateway first, and exit without pinging anything else if it does not reply")
+	flagGateway             = flag.String("gateway", "", "gateway for --probe-gateway-first (default: from the routing table, Linux only)")
+	flagMaxRuntime          = flag.Duration("max-runtime", 0, "exit with status 124 if the whole run takes longer than this")
+	flagSourceSet           = flag.StringSlice("source-set", nil, "ping each target once from each of these local addresses")
+	flagCountBytes          = flag.Bool("count-received-bytes", false, "after the run, log the ICMP bytes sent and received and the resulting goodput")
+	flagV4ID                = flag.Int("v4-id", -1, "ICMP identifier for IPv4 requests, or -1 to derive it from the process ID")
+	flagV6ID                = flag.Int("v6-id", -1, "ICMP identifier for IPv6 requests, or -1 for the IPv4 identifier with the top bit flipped")
+	flagIPutilsPayload      = flag.Bool("iputils-payload", false, "send the same 56-byte data as iputils ping: a timeval of the send time followed by a byte pattern")
+	flagOrderedOutput       = flag.Bool("ordered-output", false, "report the results for the addresses of each host in resolution order, once all of them are done")
+	flagResolveOnly         = flag.Bool("resolve-only", false, "print the addresses each target resolves to, and exit")
+	flagUnreachableDown     = flag.Bool("treat-unreachable-as-down", true, "count Destination Unreachable as a lost request and a down host; with =false it counts as a response")
+	flagRecord              = flag.String("record", "", "append every ICMP message read while waiting for replies to this file, as JSON lines")
+	flagReplay              = flag.String("replay", "", "report the replies recorded in this --record file, without touching the network")
+	flagLatencyClasses      = flag.String("latency-classes", "", "label each reply with a latency band, given as name=bound pairs in ascending order and a final catch-all name, e.g. good=50ms,fair=150ms,poor")
+	flagLabelFromPTR        = flag.Bool("label-from-ptr", false, "label each replying address with its reverse DNS name in output and summaries, or \"no PTR\" if it has none")
+	flagSimulate            = flag.String("simulate-from-file", "", "report the requests scripted in this file instead of pinging (testing only)")
+	flagMinTTL              = flag.Int("min-ttl", 0, "warn about replies whose TTL or hop limit is below this, as a sign of an unexpectedly long path")
+	flagFailOnTTL           = flag.Bool("fail-on-ttl", false, "exit with status 1 if any reply had a TTL below --min-ttl")
 )
 
+// v6Interface is the interface named by --v6-interface, if any.
+var v6Interface *net.Interface
+
+// exitMaxRuntime is the exit status when --max-runtime is exceeded, as used
+// by timeout(1).
+const exitMaxRuntime = 124
+
+// echoID and echoID6 are the ICMP identifiers used for IPv4 and IPv6
+// requests sent by this process. They differ, so that captures of
+// dual-stack runs can tell the families apart by ID alone.
+var (
+	echoID  = os.Getpid() & 0xffff
+	echoID6 = echoID ^ 0x8000
+)
+
+// hostID returns the ICMP identifier to use for requests to host over
+// IPv6 if v6 is set, or IPv4 otherwise: echoID or echoID6, or with
+// --id-per-host a stable 16-bit hash of the target, again with the top
+// bit flipped for IPv6.
+func hostID(host string, v6 bool) int {
+	if !*flagIDPerHost {
+		if v6 {
+			return echoID6
+		}
+		return echoID
+	}
+	h := fnv.New32a()
+	h.Write([]byte(host))
+	sum := h.Sum32()
+	id := int(sum>>16^sum) & 0xffff
+	if v6 {
+		id ^= 0x8000
+	}
+	return id
+}
+
+// warnIDCollisions logs every pair of targets that --id-per-host maps to
+// the same identifier. With only 65536 identifiers, collisions are likely
+// beyond a few hundred targets; they are harmless with --match-nonce.
+func warnIDCollisions(targets []string) {
+	seen := make(map[int]string)
+	for _, t := range targets {
+		id := hostID(t, false)
+		if other, ok := seen[id]; ok && other != t {
+			log.Printf("warning: %s and %s both use ICMP ID %#04x; their replies may be confused", other, t, id)
+			continue
+		}
+		seen[id] = t
+	}
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s [OPTIONS] [ADDR...]\n\n", os.Args[0])
+	flag.PrintDefaults()
+	fmt.Fprintf(os.Stderr, "\nEvery option can also be set with a %s environment variable,\n"+
+		"e.g. %s=2s. Command-line flags take precedence over the environment.\n", envPrefix, envName("timeout"))
+}
+
 func main() {
+	flag.Usage = usage
+	flag.CommandLine.MarkHidden("fake-loss")
+	flag.CommandLine.MarkHidden("seed")
+	flag.CommandLine.MarkHidden("simulate-from-file")
 	flag.Parse()
+	if err := applyEnv(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if *flagConfig != "" {
+		if err := applyConfigFile(*flagConfig); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+
+	if *flagPrintConfig {
+		if err := printConfig(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *flagListInterfaces {
+		if err := listInterfaces(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Validate every option before any mode that could use it.
+	var err error
+	if *flagRecvBuf <= 0 || *flagRecvBuf > 65535 {
+		fmt.Fprintf(os.Stderr, "invalid --recv-buffer-size %d: must be between 1 and 65535\n", *flagRecvBuf)
+		os.Exit(1)
+	}
+
+	// A fwmark is 32 bits, and 0 means no mark.
+	if flag.CommandLine.Changed("routing-table") && (*flagRoutingTable < 1 || int64(*flagRoutingTable) > math.MaxUint32) {
+		fmt.Fprintf(os.Stderr, "invalid --routing-table %d: must be between 1 and %d\n", *flagRoutingTable, uint32(math.MaxUint32))
+		os.Exit(1)
+	}
+
+	if *flagV6Interface != "" {
+		v6Interface, err = net.InterfaceByName(*flagV6Interface)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid --v6-interface %q: %v (valid interfaces: %s)\n", *flagV6Interface, err, strings.Join(interfaceNames(), ", "))
+			os.Exit(1)
+		}
+	}
+
+	if *flagFormat != "" {
+		outputTemplate, err = template.New("format").Parse(*flagFormat + "\n")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid --format: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if *flagHostsFileOnly {
+		resolver = hostsFileResolver{path: *flagHostsFile}
+	}
+
+	if *flagReportTemplate != "" {
+		reportTemplate, err = parseReportTemplate(*flagReportTemplate)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid --report-template: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if *flagRTTUnit != "" && !rttUnits[*flagRTTUnit] {
+		fmt.Fprintf(os.Stderr, "invalid --rtt-unit %q: must be ms, us, ns or auto\n", *flagRTTUnit)
+		os.Exit(1)
+	}
+
+	if *flagPayloadFile != "" {
+		if flag.CommandLine.Changed("data") {
+			fmt.Fprintln(os.Stderr, "--data and --payload-file are mutually exclusive")
+			os.Exit(1)
+		}
+		b, err := os.ReadFile(*flagPayloadFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid --payload-file: %v\n", err)
+			os.Exit(1)
+		}
+		*flagData = b
+	}
+	if *flagIPutilsPayload && len(*flagData) > 0 {
+		fmt.Fprintln(os.Stderr, "--iputils-payload cannot be combined with --data or --payload-file")
+		os.Exit(1)
+	}
+	if max := maxData(); len(*flagData) > max {
+		fmt.Fprintf(os.Stderr, "request data is %d bytes, but at most %d fit in an echo request\n", len(*flagData), max)
+		os.Exit(1)
+	}
+
+	if *flagSpoofSource != "" {
+		spoofSource = net.ParseIP(*flagSpoofSource).To4()
+		if spoofSource == nil {
+			fmt.Fprintf(os.Stderr, "invalid --spoof-source %q: must be an IPv4 address\n", *flagSpoofSource)
+			os.Exit(1)
+		}
+		if !*flagIKnowWhatImDoing {
+			fmt.Fprintln(os.Stderr, "--spoof-source sends packets whose replies never return to this host; pass --i-know-what-im-doing to use it")
+			os.Exit(1)
+		}
+	}
+
+	switch *flagStrictReplyType {
+	case "error", "warn", "loss":
+	default:
+		fmt.Fprintf(os.Stderr, "invalid --strict-reply-type %q: must be error, warn or loss\n", *flagStrictReplyType)
+		os.Exit(1)
+	}
+
+	if *flagWarmup < 0 {
+		fmt.Fprintf(os.Stderr, "invalid --warmup %d: must not be negative\n", *flagWarmup)
+		os.Exit(1)
+	}
+
+	if *flagSyslog {
+		if err := openSyslog(*flagSyslogAddr, *flagSyslogFacility); err != nil {
+			fmt.Fprintf(os.Stderr, "--syslog: %v\n", err)
+			os.Exit(1)
+		}
+	} else if *flagSyslogOnly {
+		fmt.Fprintln(os.Stderr, "--syslog-only requires --syslog")
+		os.Exit(1)
+	}
+
+	switch *flagIPv6ExtHeader {
+	case "", "hop-by-hop", "dst-opts":
+	default:
+		fmt.Fprintf(os.Stderr, "invalid --ipv6-ext-header %q: must be hop-by-hop or dst-opts\n", *flagIPv6ExtHeader)
+		os.Exit(1)
+	}
+
+	if *flagMinTTL < 0 || *flagMinTTL > 255 {
+		fmt.Fprintf(os.Stderr, "invalid --min-ttl %d: must be between 0 and 255\n", *flagMinTTL)
+		os.Exit(1)
+	}
+	if *flagFailOnTTL && *flagMinTTL == 0 {
+		fmt.Fprintln(os.Stderr, "--fail-on-ttl requires --min-ttl")
+		os.Exit(1)
+	}
+
+	if *flagLatencyClasses != "" {
+		latencyClasses, err = parseLatencyClasses(*flagLatencyClasses)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid --latency-classes: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if len(*flagSourceSet) > 0 {
+		sourceSet, err = parseSourceSet(*flagSourceSet)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid --source-set: %v\n", err)
+			os.Exit(1)
+		}
+		if *flagAllInterfaces {
+			fmt.Fprintln(os.Stderr, "--source-set and --listen-all-interfaces are mutually exclusive")
+			os.Exit(1)
+		}
+	}
+
+	if *flagRecord != "" {
+		if *flagReplay != "" {
+			fmt.Fprintln(os.Stderr, "--record and --replay are mutually exclusive")
+			os.Exit(1)
+		}
+		if err := openRecord(*flagRecord); err != nil {
+			fmt.Fprintf(os.Stderr, "invalid --record: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if *flagOnlyAlive && *flagOnlyDead {
+		fmt.Fprintln(os.Stderr, "--only-alive and --only-dead are mutually exclusive")
+		os.Exit(1)
+	}
+
+	for _, id := range []struct {
+		flag string
+		val  int
+		dst  *int
+	}{{"v4-id", *flagV4ID, &echoID}, {"v6-id", *flagV6ID, &echoID6}} {
+		if id.val == -1 {
+			continue
+		}
+		if id.val < 0 || id.val > 0xffff {
+			fmt.Fprintf(os.Stderr, "invalid --%s %d: must be between 0 and 65535\n", id.flag, id.val)
+			os.Exit(1)
+		}
+		if *flagIDPerHost {
+			fmt.Fprintf(os.Stderr, "--%s and --id-per-host are mutually exclusive\n", id.flag)
+			os.Exit(1)
+		}
+		*id.dst = id.val
+	}
+	if *flagVerbose && !*flagIDPerHost {
+		log.Printf("using ICMP ID %#04x for IPv4 and %#04x for IPv6", echoID, echoID6)
+	}
+
+	if *flagSelfTest {
+		if !selfTest() {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *flagReceiveOnly {
+		if *flagSendOnly {
+			fmt.Fprintln(os.Stderr, "--send-only and --receive-only are mutually exclusive")
+			os.Exit(1)
+		}
+		if err := receiveOnly(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
 
 	args := flag.Args()
 	if len(args) == 0 {
+		args = configTargets
+	}
+	if *flagTargetsURL != "" {
+		targets, err := fetchTargets(*flagTargetsURL, *flagTargetsURLInsecure)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		args = append(args, targets...)
+	}
+	if len(args) == 0 && *flagSimulate == "" && *flagReplay == "" {
 		fmt.Fprintf(os.Stderr, "Usage: %s [ADDR...]\n", os.Args[0])
 		os.Exit(1)
 	}
 
+	args, err = expandTargets(args)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	if *flagNoResolve {
+		for _, addr := range args {
+			if net.ParseIP(addr) == nil {
+				fmt.Fprintf(os.Stderr, "--no-resolve: %q is not a literal IP address\n", addr)
+				os.Exit(1)
+			}
+		}
+	}
+
+	if *flagIDPerHost && !*flagMatchNonce {
+		warnIDCollisions(args)
+	}
+
+	if *flagResolveOnly {
+		if !resolveOnly(args) {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *flagDryRun {
+		dryRun(args)
+		return
+	}
+
+	if *flagOutputBuffered {
+		startBufferedOutput()
+	}
+
+	if *flagMaxRuntime > 0 {
+		time.AfterFunc(*flagMaxRuntime, func() {
+			log.Printf("exceeded --max-runtime of %s, exiting", *flagMaxRuntime)
+			exit(exitMaxRuntime)
+		})
+	}
+
+	if *flagQuietErrors {
+		startQuietErrors()
+	}
+
+	start := time.Now()
+	if *flagReplay != "" {
+		if err := replay(*flagReplay); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			exit(1)
+		}
+		finishRun(start, false)
+		return
+	}
+	if *flagSimulate != "" {
+		if err := simulate(*flagSimulate); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			exit(1)
+		}
+		finishRun(start, false)
+		return
+	}
+
+	checkFamilies()
+
+	if *flagProbeGatewayFirst && !probeGateway() {
+		exit(1)
+	}
+
+	var prog *progress
+	if *flagProgress {
+		prog = startProgress(len(args))
+	}
+	var failed bool
 	for _, addr := range args {
+		hostEntry(addr)
 		if err := ping(addr); err != nil {
 			log.Printf("[%s] error: %v", addr, err)
+			failed = true
+		}
+		if prog != nil {
+			prog.hostDone()
 		}
 	}
+	if prog != nil {
+		prog.finish()
+	}
+	finishRun(start, failed)
 }
 
-func ping(addr string) error {
-	addrs, err := net.LookupHost(addr)
+// finishRun prints everything reported once all hosts are done, for a run
+// that started at start, and exits with status 1 if it failed.
+func finishRun(start time.Time, failed bool) {
+	if *flagOnlyAlive || *flagOnlyDead {
+		reportFiltered()
+	}
+	if *flagCountBytes {
+		logByteCounts(allHosts(), time.Since(start))
+	}
+	if *flagHistogramBuckets > 0 {
+		printHistogram(allHosts(), *flagHistogramBuckets)
+	}
+	if len(latencyClasses) > 0 {
+		logClassCounts(allHosts())
+	}
+	if *flagInflux && outputTemplate == nil && !*flagSyslogOnly {
+		now := clock.Now()
+		for _, h := range allHosts() {
+			if line := formatInfluxSummary(h, now); line != "" {
+				fmt.Fprintln(stdout, line)
+			}
+		}
+	}
+	if reportTemplate != nil {
+		if err := writeReport(allHosts()); err != nil {
+			log.Printf("error rendering --report-template: %v", err)
+		}
+	}
+	if *flagSummaryJSONFile != "" {
+		if err := writeSummaryJSON(*flagSummaryJSONFile, allHosts()); err != nil {
+			log.Printf("error writing --summary-json-file: %v", err)
+		}
+	}
+	logExcluded()
+	if *flagRequireAllUp && !allUp() {
+		failed = true
+	}
+	if *flagFailOnTTL && !ttlsAboveMin() {
+		failed = true
+	}
+	if failed {
+		exit(1)
+	}
+	reportRepeatedErrors()
+	flushOutput()
+}
+
+// reportFiltered prints the results of the hosts selected by --only-alive
+// or --only-dead, once every host has been pinged.
+func reportFiltered() {
+	for _, h := range allHosts() {
+		if h.up() != *flagOnlyAlive {
+			continue
+		}
+		if len(h.results) == 0 {
+			log.Printf("[%s] no results", h.host)
+		}
+		for _, r := range h.results {
+			report(r)
+		}
+	}
+}
+
+// allUp reports whether every host replied, for --require-all-up, logging
+// the ones that did not.
+func allUp() bool {
+	var down []string
+	for _, h := range allHosts() {
+		if !h.up() {
+			down = append(down, h.host)
+		}
+	}
+	if len(down) > 0 {
+		log.Printf("--require-all-up: %d of %d hosts never replied: %s", len(down), len(allHosts()), strings.Join(down, ", "))
+	}
+	return len(down) == 0
+}
+
+// ttlsAboveMin reports whether no reply had a TTL below --min-ttl, logging
+// how many did otherwise, for --fail-on-ttl.
+func ttlsAboveMin() bool {
+	var below, replies int
+	for _, h := range allHosts() {
+		for _, r := range h.results {
+			if r.Status == statusReply {
+				replies++
+			}
+			if r.TTLBelowMin {
+				below++
+			}
+		}
+	}
+	if below > 0 {
+		log.Printf("--fail-on-ttl: %d of %d replies had a ttl below %d", below, replies, *flagMinTTL)
+	}
+	return below == 0
+}
+
+// probeGateway pings the gateway for --probe-gateway-first and reports
+// whether it replied.
+func probeGateway() bool {
+	gw := net.ParseIP(*flagGateway)
+	if *flagGateway == "" {
+		var err error
+		if gw, err = defaultGateway(); err != nil {
+			log.Printf("--probe-gateway-first: %v", err)
+			return false
+		}
+	} else if gw == nil {
+		log.Printf("--probe-gateway-first: invalid --gateway %q", *flagGateway)
+		return false
+	}
+
+	r, err := pingIP("gateway", gw)
+	switch {
+	case err != nil:
+		log.Printf("[gateway] %s: error: %v; not pinging targets", gw, err)
+		return false
+	case r.Status != statusReply:
+		log.Printf("[gateway] %s: %s in %s; not pinging targets, since the local network looks broken", gw, r.Status, formatRTT(r.RTT))
+		return false
+	}
+	log.Printf("[gateway] %s: got reply in %s", gw, formatRTT(r.RTT))
+	return true
+}
+
+// resolve looks up addr and returns the parsed IP addresses it refers to.
+func resolve(addr string) ([]net.IP, error) {
+	if *flagNoResolve {
+		ip := net.ParseIP(addr)
+		if ip == nil {
+			return nil, fmt.Errorf("%q is not a literal IP address", addr)
+		}
+		if ip4 := ip.To4(); ip4 != nil {
+			ip = ip4
+		}
+		return filterExcluded(addr, []net.IP{ip}), nil
+	}
+
+	addrs, err := resolver.LookupHost(context.Background(), addr)
 	if err != nil {
-		return fmt.Errorf("error resolving %q: %v", addr, err)
+		return nil, fmt.Errorf("error resolving %q: %v", addr, err)
 	}
 
-	var wg sync.WaitGroup
+	var ips []net.IP
 	for _, raddr := range addrs {
 		ip := net.ParseIP(raddr)
 		if ip == nil {
-			log.Printf("[%s] error parsing address %q: %v", addr, raddr, err)
+			log.Printf("[%s] error parsing address %q", addr, raddr)
 			continue
 		}
+		// Normalize v4-mapped IPv6 addresses (::ffff:a.b.c.d) to plain
+		// IPv4, so they are pinged over an IPv4 socket with ICMP rather
+		// than ICMPv6.
+		if ip4 := ip.To4(); ip4 != nil {
+			ip = ip4
+		}
+		ips = append(ips, ip)
+	}
+	// Some resolvers report success with no addresses; treat that as a
+	// failure rather than silently pinging nothing.
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("no addresses found for %q", addr)
+	}
+	return filterExcluded(addr, ips), nil
+}
+
+// resolveOnly prints every address of each target with its family, for
+// --resolve-only, and reports whether every target resolved. Failures are
+// printed to stderr, so stdout only ever holds addresses.
+func resolveOnly(args []string) bool {
+	ok := true
+	for _, addr := range args {
+		ips, err := resolve(addr)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\terror\t%v\n", addr, err)
+			ok = false
+			continue
+		}
+		for _, ip := range ips {
+			fmt.Printf("%s\t%s\t%s\n", addr, ipFamily(ip), ip)
+		}
+	}
+	logExcluded()
+	return ok
+}
+
+// ipFamily returns the name of the address family of ip, "ipv4" or "ipv6",
+// as printed by --resolve-only and --dry-run.
+func ipFamily(ip net.IP) string {
+	if ip.To4() != nil {
+		return "ipv4"
+	}
+	return "ipv6"
+}
+
+// dryRun resolves each address and prints what would be pinged, in the
+// same columns as --resolve-only, without opening any sockets.
+func dryRun(args []string) {
+	var total int
+	for _, addr := range args {
+		ips, err := resolve(addr)
+		if err != nil {
+			log.Printf("[%s] error: %v", addr, err)
+			continue
+		}
+		for _, ip := range ips {
+			fmt.Printf("%s\t%s\t%s\n", addr, ipFamily(ip), ip)
+			total++
+		}
+	}
+	logExcluded()
+	fmt.Printf("would send %d packets (one per target)\n", total)
+}
+
+func ping(addr string) error {
+	ips, err := resolve(addr)
+	if err != nil {
+		return err
+	}
+	if len(ips) == 0 {
+		// Excluding every address is what was asked for, not a failure.
+		log.Printf("[%s] every address is excluded, not pinging", addr)
+		return nil
+	}
+	ips, err = availableIPs(ips)
+	if err != nil {
+		return err
+	}
 
+	if spoofSource != nil {
+		return sendSpoofed(addr, ips)
+	}
+	if *flagProbeBoth {
+		return raceFamilies(addr, ips)
+	}
+	if *flagFirstReplyWins {
+		return firstReply(addr, ips)
+	}
+
+	sources := sourceSet
+	if *flagAllInterfaces {
+		sources, err = interfaceSources()
+		if err != nil {
+			return err
+		}
+	}
+
+	// With --ordered-output, each address's outcome is saved in its slot,
+	// and reported once every address is done.
+	type outcome struct {
+		r     result
+		err   error
+		ip    net.IP
+		iface string
+	}
+	var outcomes []*outcome
+	finish := func(o *outcome) {
+		if o.err != nil {
+			if o.iface != "" {
+				log.Printf("[%s] %s via %s: error: %v", addr, o.ip, o.iface, o.err)
+			} else {
+				log.Printf("[%s] error: %v", addr, o.err)
+			}
+			return
+		}
+		o.r.Iface = o.iface
+		handleResult(o.r)
+	}
+
+	var wg sync.WaitGroup
+	spawn := func(ip net.IP, listen, device, iface string) {
+		o := &outcome{ip: ip, iface: iface}
+		if *flagOrderedOutput {
+			outcomes = append(outcomes, o)
+		}
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			if ip4 := ip.To4(); ip4 != nil {
-				ipa := &net.IPAddr{IP: ip4}
-				if err := ping4(addr, ipa); err != nil {
-					log.Printf("[%s] error: %v", addr, err)
-				}
-
-			} else if ip6 := ip.To16(); ip6 != nil {
-				ipa := &net.IPAddr{IP: ip6}
-				if err := ping6(addr, ipa); err != nil {
-					log.Printf("[%s] error: %v", addr, err)
-				}
-
-			} else {
-				log.Printf("[%s] unexpected IP type", addr)
+			o.r, o.err = pingFrom(addr, ip, listen, device)
+			if !*flagOrderedOutput {
+				finish(o)
 			}
 		}()
 	}
+	for _, ip := range ips {
+		if *flagBindSourcePerTarget {
+			src, err := routeSource(ip)
+			if err != nil {
+				log.Printf("[%s] %s: error finding source address: %v", addr, ip, err)
+				continue
+			}
+			if *flagVerbose {
+				log.Printf("[%s] %s: using source address %s", addr, ip, src)
+			}
+			spawn(ip, src.String(), "", "")
+			continue
+		}
+		if len(sources) == 0 {
+			spawn(ip, defaultListen(ip), "", "")
+			continue
+		}
+		for _, src := range sources {
+			if src.loopback && !ip.IsLoopback() {
+				continue
+			}
+			if (src.ip.To4() != nil) == (ip.To4() != nil) {
+				spawn(ip, src.ip.String(), src.device, src.iface)
+			}
+		}
+	}
 
 	wg.Wait()
+	for _, o := range outcomes {
+		finish(o)
+	}
 	return nil
 }
 
-func ping4(addr string, resolved *net.IPAddr) error {
-	c, err := icmp.ListenPacket("ip4:icmp", *flagListen4)
-	if err != nil {
-		return err
+// handleResult reports r and applies any policy that depends on the
+// outcome of a single ping.
+func handleResult(r result) {
+	r.Class = classify(r)
+	if *flagLabelFromPTR && r.Status == statusReply {
+		r.Label = ptrLabel(r.IP)
+	}
+	if *flagMinTTL > 0 && r.Status == statusReply && r.TTL > 0 && r.TTL < *flagMinTTL {
+		r.TTLBelowMin = true
+		log.Printf("[%s] %s: warning: reply ttl %d is below --min-ttl %d", r.Host, r.IP, r.TTL, *flagMinTTL)
+	}
+	record(r)
+	if !*flagOnlyAlive && !*flagOnlyDead {
+		report(r)
 	}
-	defer c.Close()
 
-	m := icmp.Message{
-		Type: ipv4.ICMPTypeEcho,
-		Code: 0,
-		Body: &icmp.Echo{
-			ID:   os.Getpid() & 0xffff,
-			Seq:  1,
-			Data: *flagData,
-		},
+	if *flagFailFast {
+		if (r.Status == statusUnreachable && *flagUnreachableDown) || (*flagFailFastTimeout && r.Status == statusTimeout) {
+			log.Printf("[%s] %s: %s, exiting due to --fail-fast", r.Host, r.IP, r.Status)
+			exit(1)
+		}
 	}
-	b, err := m.Marshal(nil)
-	if err != nil {
-		return err
+}
+
+// pingIP pings a single resolved address of addr from the default listen
+// address for its family.
+func pingIP(addr string, ip net.IP) (result, error) {
+	return pingFrom(addr, ip, defaultListen(ip), "")
+}
+
+// defaultListen returns the --listen4 or --listen6 address, depending on the
+// family of ip.
+func defaultListen(ip net.IP) string {
+	if ip.To4() != nil {
+		return *flagListen4
 	}
+	return *flagListen6
+}
 
-	start := time.Now()
-	n, err := c.WriteTo(b, resolved)
+// pingFrom pings a single resolved address of addr from the local address
+// listen, bound to the interface device if it is not empty, after any
+// --warmup requests. With --verbose or
+// --summary-json-file, the result records its egress interface.
+func pingFrom(addr string, ip net.IP, listen, device string) (result, error) {
+	checkMTU(addr, ip)
+	for seq := 1; seq <= *flagWarmup; seq++ {
+		r, err := pingSeq(addr, ip, listen, device, seq)
+		if err != nil {
+			log.Printf("[%s] %s: warmup %d: error: %v", addr, ip, seq, err)
+			continue
+		}
+		if humanOutput() {
+			log.Printf("[%s] %s: warmup %d: %s in %s", addr, ip, seq, r.Status, formatRTT(r.RTT))
+		}
+	}
+	r, err := pingSeq(addr, ip, listen, device, *flagWarmup+1)
+	if err != nil || !(*flagVerbose || *flagSummaryJSONFile != "") {
+		return r, err
+	}
+	r.Egress, err = egressInterface(ip, listen)
 	if err != nil {
-		return err
-	} else if n != len(b) {
-		return fmt.Errorf("got %v; want %v", n, len(b))
+		r.Egress = egressUnknown
+		if *flagVerbose {
+			log.Printf("[%s] %s: cannot determine egress interface: %v", addr, ip, err)
+		}
 	}
+	return r, nil
+}
 
-	reply := make([]byte, 1500)
-	err = c.SetReadDeadline(time.Now().Add(*flagTimeout))
-	if err != nil {
-		return err
+// pingSeq sends a single echo request with sequence number seq, using the
+// socket type appropriate for the address family of ip.
+func pingSeq(addr string, ip net.IP, listen, device string, seq int) (result, error) {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ping4(addr, &net.IPAddr{IP: ip4}, listen, device, seq)
+	} else if ip6 := ip.To16(); ip6 != nil {
+		return ping6(addr, &net.IPAddr{IP: ip6}, listen, device, seq)
 	}
-	n, peer, err := c.ReadFrom(reply)
-	duration := time.Since(start)
-	if err != nil {
-		if opErr, ok := err.(*net.OpError); ok {
-			if opErr.Timeout() {
-				log.Printf("[%s] %s: request timeout in %s", addr, resolved.IP, duration)
-				return nil
+	return result{}, fmt.Errorf("unexpected IP type")
+}
+
+// firstReply pings every address of addr at the same time and reports only
+// the first one to reply, by the source address of its reply. Requests to
+// the other addresses are abandoned.
+func firstReply(addr string, ips []net.IP) error {
+	results := make(chan result, len(ips))
+	for _, ip := range ips {
+		ip := ip
+		go func() {
+			r, err := pingIP(addr, ip)
+			if err != nil {
+				log.Printf("[%s] %s: error: %v", addr, ip, err)
 			}
+			results <- r
+		}()
+	}
+
+	for range ips {
+		r := <-results
+		// matchEcho only accepts replies from the pinged address, so a
+		// reply reaching every socket cannot make them all winners.
+		if r.Status == statusReply && r.Peer.Equal(r.IP) {
+			handleResult(r)
+			log.Printf("[%s] first reply from %s (of %d addresses)", addr, r.Peer, len(ips))
+			return nil
 		}
-		return err
 	}
+	log.Printf("[%s] no reply from any of %d addresses", addr, len(ips))
+	return nil
+}
 
-	rm, err := icmp.ParseMessage(ProtocolICMP, reply[:n])
-	if err != nil {
-		return err
+// raceFamilies pings the first IPv4 and the first IPv6 address of addr at
+// the same time and reports which family replied first.
+func raceFamilies(addr string, ips []net.IP) error {
+	var v4, v6 net.IP
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			if v4 == nil {
+				v4 = ip
+			}
+		} else if v6 == nil {
+			v6 = ip
+		}
 	}
-	switch rm.Type {
-	case ipv4.ICMPTypeEchoReply:
-		log.Printf("[%s] %s: got reply in %s", addr, resolved.IP, duration)
+	if v4 == nil || v6 == nil {
+		return fmt.Errorf("--probe-both-and-report-winner needs both an IPv4 and an IPv6 address")
+	}
+
+	var (
+		wg         sync.WaitGroup
+		r4, r6     result
+		err4, err6 error
+	)
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		r4, err4 = pingIP(addr, v4)
+	}()
+	go func() {
+		defer wg.Done()
+		r6, err6 = pingIP(addr, v6)
+	}()
+	wg.Wait()
 
-	case ipv4.ICMPTypeDestinationUnreachable:
-		log.Printf("[%s] %s: destination unreachable in %s", addr, resolved.IP, duration)
+	if err4 != nil {
+		log.Printf("[%s] error: %v", addr, err4)
+	} else {
+		handleResult(r4)
+	}
+	if err6 != nil {
+		log.Printf("[%s] error: %v", addr, err6)
+	} else {
+		handleResult(r6)
+	}
 
+	ok4 := err4 == nil && r4.Status == statusReply
+	ok6 := err6 == nil && r6.Status == statusReply
+	switch {
+	case ok4 && ok6:
+		winner, delta := "IPv4", r6.RTT-r4.RTT
+		if r6.RTT < r4.RTT {
+			winner, delta = "IPv6", r4.RTT-r6.RTT
+		}
+		log.Printf("[%s] winner: %s by %s (IPv4 %s, IPv6 %s)", addr, winner, formatRTT(delta), formatRTT(r4.RTT), formatRTT(r6.RTT))
+	case ok4:
+		log.Printf("[%s] winner: IPv4 (%s); IPv6 did not reply", addr, formatRTT(r4.RTT))
+	case ok6:
+		log.Printf("[%s] winner: IPv6 (%s); IPv4 did not reply", addr, formatRTT(r6.RTT))
 	default:
-		return fmt.Errorf("got %+v from %v; want echo reply", rm, peer)
+		log.Printf("[%s] no winner: neither family replied", addr)
 	}
-
 	return nil
 }
 
-func ping6(addr string, resolved *net.IPAddr) error {
-	c, err := icmp.ListenPacket("ip6:icmp", *flagListen6)
+func ping4(addr string, resolved *net.IPAddr, listen, device string, seq int) (result, error) {
+	c, err := net.ListenPacket("ip4:icmp", listen)
 	if err != nil {
-		return err
+		return result{}, err
 	}
 	defer c.Close()
+	if device != "" {
+		if err := bindToDevice(c, device, false); err != nil {
+			return result{}, fmt.Errorf("binding to %s: %v", device, err)
+		}
+	}
+	if *flagRoutingTable != 0 {
+		if err := setRoutingMark(c, *flagRoutingTable); err != nil {
+			return result{}, fmt.Errorf("setting routing mark: %v", err)
+		}
+	}
 
+	req := newRequest(addr, resolved, seq)
 	m := icmp.Message{
-		Type: ipv6.ICMPTypeEchoRequest,
+		Type: ipv4.ICMPTypeEcho,
 		Code: 0,
 		Body: &icmp.Echo{
-			ID:   os.Getpid() & 0xffff,
-			Seq:  1,
-			Data: *flagData,
+			ID:   req.id,
+			Seq:  req.seq,
+			Data: req.payload,
 		},
 	}
 	b, err := m.Marshal(nil)
 	if err != nil {
-		return err
+		return result{}, err
 	}
 
-	start := time.Now()
+	p := ipv4.NewPacketConn(c)
+	if err := p.SetControlMessage(ipv4.FlagTTL|ipv4.FlagDst|ipv4.FlagInterface, true); err != nil {
+		return result{}, err
+	}
+
+	req.start = clock.Now()
+	if *flagDumpRaw {
+		dumpRaw("sent", req, b)
+	}
 	n, err := c.WriteTo(b, resolved)
 	if err != nil {
-		return err
+		return sendFailure(req, err)
 	} else if n != len(b) {
-		return fmt.Errorf("got %v; want %v", n, len(b))
+		return result{}, fmt.Errorf("got %v; want %v", n, len(b))
+	}
+	if *flagSendOnly {
+		return req.result(statusSent, 0), nil
 	}
 
-	reply := make([]byte, 1500)
 	err = c.SetReadDeadline(time.Now().Add(*flagTimeout))
 	if err != nil {
-		return err
+		return result{}, err
+	}
+	var pr packetReader = ipv4Reader{p}
+	if *flagKernelTimestamps {
+		if tr := newTimestampReader(c, familyIPv4); tr != nil {
+			pr = tr
+		}
 	}
-	n, peer, err := c.ReadFrom(reply)
-	duration := time.Since(start)
+	return readReply(pr, familyIPv4, req)
+}
+
+func ping6(addr string, resolved *net.IPAddr, listen, device string, seq int) (result, error) {
+	c, err := net.ListenPacket("ip6:icmp", listen)
 	if err != nil {
-		if opErr, ok := err.(*net.OpError); ok {
-			if opErr.Timeout() {
-				log.Printf("[%s] %s: request timeout in %s", addr, resolved.IP, duration)
-				return nil
-			}
+		return result{}, err
+	}
+	defer c.Close()
+	if device != "" {
+		if err := bindToDevice(c, device, true); err != nil {
+			return result{}, fmt.Errorf("binding to %s: %v", device, err)
+		}
+	}
+	if *flagIPv6ExtHeader != "" {
+		if err := setIPv6ExtHeader(c, *flagIPv6ExtHeader); err != nil {
+			return result{}, fmt.Errorf("adding extension header: %v", err)
+		}
+	}
+	if *flagRoutingTable != 0 {
+		if err := setRoutingMark(c, *flagRoutingTable); err != nil {
+			return result{}, fmt.Errorf("setting routing mark: %v", err)
 		}
-		return err
 	}
 
-	rm, err := icmp.ParseMessage(ProtocolIPv6ICMP, reply[:n])
+	req := newRequest(addr, resolved, seq)
+	m := icmp.Message{
+		Type: ipv6.ICMPTypeEchoRequest,
+		Code: 0,
+		Body: &icmp.Echo{
+			ID:   req.id,
+			Seq:  req.seq,
+			Data: req.payload,
+		},
+	}
+	b, err := m.Marshal(nil)
 	if err != nil {
-		return err
+		return result{}, err
 	}
-	switch rm.Type {
-	case ipv6.ICMPTypeEchoReply:
-		log.Printf("[%s] %s: got reply in %s", addr, resolved.IP, duration)
 
-	case ipv6.ICMPTypeDestinationUnreachable:
-		log.Printf("[%s] %s: destination unreachable in %s", addr, resolved.IP, duration)
+	p := ipv6.NewPacketConn(c)
+	if err := p.SetControlMessage(ipv6.FlagHopLimit|ipv6.FlagDst|ipv6.FlagInterface, true); err != nil {
+		return result{}, err
+	}
 
-	default:
-		return fmt.Errorf("got %+v from %v; want echo reply", rm, peer)
+	var cm *ipv6.ControlMessage
+	if v6Interface != nil {
+		if err := p.SetMulticastInterface(v6Interface); err != nil {
+			return result{}, err
+		}
+		cm = &ipv6.ControlMessage{IfIndex: v6Interface.Index}
 	}
 
-	return nil
+	req.start = clock.Now()
+	if *flagDumpRaw {
+		dumpRaw("sent", req, b)
+	}
+	n, err := p.WriteTo(b, cm, resolved)
+	if err != nil {
+		return sendFailure(req, err)
+	} else if n != len(b) {
+		return result{}, fmt.Errorf("got %v; want %v", n, len(b))
+	}
+	if *flagSendOnly {
+		return req.result(statusSent, 0), nil
+	}
+
+	err = c.SetReadDeadline(time.Now().Add(*flagTimeout))
+	if err != nil {
+		return result{}, err
+	}
+	var pr packetReader = ipv6Reader{p}
+	if *flagKernelTimestamps {
+		if tr := newTimestampReader(c, familyIPv6); tr != nil {
+			pr = tr
+		}
+	}
+	return readReply(pr, familyIPv6, req)
 }
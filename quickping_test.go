@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+// stubResolver answers every lookup with the same addresses.
+type stubResolver []string
+
+func (r stubResolver) LookupHost(ctx context.Context, name string) ([]string, error) {
+	return r, nil
+}
+
+// useResolver replaces resolver with r for the rest of t.
+func useResolver(t *testing.T, r Resolver) {
+	old := resolver
+	resolver = r
+	t.Cleanup(func() { resolver = old })
+}
+
+// TestResolveV4Mapped checks that v4-mapped IPv6 addresses are normalized
+// to IPv4, so that they are pinged over an IPv4 socket.
+func TestResolveV4Mapped(t *testing.T) {
+	useResolver(t, stubResolver{"::ffff:192.0.2.1", "2001:db8::1"})
+	ips, err := resolve("example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ips) != 2 {
+		t.Fatalf("got %v; want 2 addresses", ips)
+	}
+	if len(ips[0]) != 4 || ips[0].String() != "192.0.2.1" {
+		t.Errorf("got %#v; want the 4-byte form of 192.0.2.1", ips[0])
+	}
+	if ips[1].To4() != nil || ips[1].String() != "2001:db8::1" {
+		t.Errorf("got %v; want 2001:db8::1", ips[1])
+	}
+}
+
+// TestResolveEmpty checks that a lookup succeeding with no addresses is
+// an error rather than a host with nothing to ping.
+func TestResolveEmpty(t *testing.T) {
+	useResolver(t, stubResolver{})
+	if ips, err := resolve("example.com"); err == nil {
+		t.Errorf("got %v, nil; want an error", ips)
+	}
+}
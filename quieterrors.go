@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"sync"
+	"time"
+)
+
+// repeatInterval is how often --quiet-errors reports suppressed errors.
+const repeatInterval = 10 * time.Second
+
+// errorCoalescer is a log writer that passes through the first occurrence
+// of each distinct error and counts later ones, for --quiet-errors. Errors
+// are told apart by the text after "error: ", so the same failure for
+// different hosts counts as a repeat.
+type errorCoalescer struct {
+	mu       sync.Mutex
+	w        io.Writer
+	logger   *log.Logger
+	seen     map[string]bool
+	repeated map[string]int
+	order    []string // errors with repeats, in first-repeat order
+}
+
+var coalescer *errorCoalescer
+
+// startQuietErrors installs an errorCoalescer in front of the log output
+// and starts reporting repeats every repeatInterval.
+func startQuietErrors() {
+	w := log.Writer()
+	coalescer = &errorCoalescer{
+		w:        w,
+		logger:   log.New(w, log.Prefix(), log.Flags()),
+		seen:     make(map[string]bool),
+		repeated: make(map[string]int),
+	}
+	log.SetOutput(coalescer)
+
+	go func() {
+		t := clock.NewTicker(repeatInterval)
+		defer t.Stop()
+		for range t.C() {
+			reportRepeatedErrors()
+		}
+	}()
+}
+
+func (c *errorCoalescer) Write(p []byte) (int, error) {
+	i := bytes.LastIndex(p, []byte("error: "))
+	if i < 0 {
+		return c.w.Write(p)
+	}
+	key := string(bytes.TrimSpace(p[i+len("error: "):]))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.seen[key] {
+		c.seen[key] = true
+		return c.w.Write(p)
+	}
+	if c.repeated[key] == 0 {
+		c.order = append(c.order, key)
+	}
+	c.repeated[key]++
+	return len(p), nil
+}
+
+// reportRepeatedErrors logs how many times each error was suppressed since
+// the last report. It does nothing without --quiet-errors.
+func reportRepeatedErrors() {
+	c := coalescer
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, key := range c.order {
+		c.logger.Printf("(last error repeated %d times: %s)", c.repeated[key], key)
+		delete(c.repeated, key)
+	}
+	c.order = nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/binary"
+	"net"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// quotedEcho is the part of one of our echo requests quoted back inside an
+// ICMP error or redirect message.
+type quotedEcho struct {
+	dst     net.IP // destination of the original packet
+	id, seq int    // ICMP identifier and sequence number
+}
+
+// parseQuoted parses the start of an original datagram quoted in an ICMP
+// message body: an IP header followed by at least the first 8 bytes of an
+// ICMP echo request. It returns false if b is too short or does not hold an
+// echo request.
+func parseQuoted(fam family, b []byte) (quotedEcho, bool) {
+	var (
+		dst  net.IP
+		rest []byte
+	)
+	if fam.proto == ProtocolICMP {
+		if len(b) < ipv4.HeaderLen {
+			return quotedEcho{}, false
+		}
+		hl := int(b[0]&0x0f) * 4
+		if hl < ipv4.HeaderLen || len(b) < hl+8 || b[9] != ProtocolICMP {
+			return quotedEcho{}, false
+		}
+		dst, rest = net.IP(b[16:20]), b[hl:]
+		if ipv4.ICMPType(rest[0]) != ipv4.ICMPTypeEcho {
+			return quotedEcho{}, false
+		}
+	} else {
+		// Extension headers between the IPv6 header and the ICMPv6 header
+		// are not followed; we never send any.
+		if len(b) < ipv6.HeaderLen+8 || b[6] != ProtocolIPv6ICMP {
+			return quotedEcho{}, false
+		}
+		dst, rest = net.IP(b[24:40]), b[ipv6.HeaderLen:]
+		if ipv6.ICMPType(rest[0]) != ipv6.ICMPTypeEchoRequest {
+			return quotedEcho{}, false
+		}
+	}
+
+	return quotedEcho{
+		dst: dst,
+		id:  int(binary.BigEndian.Uint16(rest[4:6])),
+		seq: int(binary.BigEndian.Uint16(rest[6:8])),
+	}, true
+}
+
+// matches reports whether q is a quote of req.
+func (q quotedEcho) matches(req *request) bool {
+	return q.dst.Equal(req.resolved.IP) && q.id == req.id && q.seq == req.seq
+}
+
+// parseRedirect extracts the suggested gateway and the quoted original
+// datagram, if any, from the body of an ICMP Redirect (IPv4) or an NDP
+// Redirect (IPv6). The body starts after the checksum.
+func parseRedirect(fam family, b []byte) (gateway net.IP, quoted []byte, ok bool) {
+	if fam.proto == ProtocolICMP {
+		// Gateway address, then the original datagram.
+		if len(b) < 4 {
+			return nil, nil, false
+		}
+		return net.IP(b[:4]), b[4:], true
+	}
+
+	// Reserved, target (the suggested next hop), destination, then NDP
+	// options; the Redirected Header option (type 4) holds the original
+	// packet after 8 bytes of option header.
+	if len(b) < 4+2*net.IPv6len {
+		return nil, nil, false
+	}
+	gateway = net.IP(b[4:20])
+	opts := b[4+2*net.IPv6len:]
+	for len(opts) >= 8 {
+		l := int(opts[1]) * 8
+		if l == 0 || l > len(opts) {
+			break
+		}
+		if opts[0] == 4 {
+			quoted = opts[8:l]
+		}
+		opts = opts[l:]
+	}
+	return gateway, quoted, true
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// receiveOnly listens on both families for --timeout and logs every echo
+// request and reply that arrives, for --receive-only. It is the other half
+// of a --send-only instance on another host; since the send time is
+// unknown here, no RTT is reported. A family whose socket cannot be opened
+// is skipped with a warning, unless neither can be.
+func receiveOnly() error {
+	deadline := time.Now().Add(*flagTimeout)
+	var readers []packetReader
+	var fams []family
+	var errs []string
+	for _, f := range []struct {
+		name, network, listen string
+		fam                   family
+	}{
+		{"ipv4", "ip4:icmp", *flagListen4, familyIPv4},
+		{"ipv6", "ip6:icmp", *flagListen6, familyIPv6},
+	} {
+		pr, err := listenEchoes(f.network, f.listen, f.fam, deadline)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", f.name, err))
+			continue
+		}
+		defer pr.Close()
+		readers = append(readers, pr)
+		fams = append(fams, f.fam)
+	}
+	if len(readers) == 0 {
+		return fmt.Errorf("cannot open an ICMP socket for either family: %s", strings.Join(errs, "; "))
+	}
+	for _, e := range errs {
+		log.Printf("warning: not listening on %s", e)
+	}
+	log.Printf("listening for echo messages for %s", *flagTimeout)
+
+	var wg sync.WaitGroup
+	errc := make([]error, len(readers))
+	for i := range readers {
+		i := i
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errc[i] = logEchoes(readers[i], fams[i])
+		}()
+	}
+	wg.Wait()
+	for _, err := range errc {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// echoListener is a packetReader over one family's socket for
+// --receive-only.
+type echoListener struct {
+	packetReader
+	c net.PacketConn
+}
+
+func (l echoListener) Close() error { return l.c.Close() }
+
+// listenEchoes opens an ICMP socket on listen that reads until deadline,
+// reporting the TTL and destination of each packet.
+func listenEchoes(network, listen string, fam family, deadline time.Time) (echoListener, error) {
+	c, err := net.ListenPacket(network, listen)
+	if err != nil {
+		return echoListener{}, err
+	}
+	var pr packetReader
+	if fam.proto == ProtocolICMP {
+		p := ipv4.NewPacketConn(c)
+		err = p.SetControlMessage(ipv4.FlagTTL|ipv4.FlagDst, true)
+		pr = ipv4Reader{p}
+	} else {
+		p := ipv6.NewPacketConn(c)
+		err = p.SetControlMessage(ipv6.FlagHopLimit|ipv6.FlagDst, true)
+		pr = ipv6Reader{p}
+	}
+	if err == nil {
+		err = c.SetReadDeadline(deadline)
+	}
+	if err != nil {
+		c.Close()
+		return echoListener{}, err
+	}
+	return echoListener{pr, c}, nil
+}
+
+// logEchoes logs every echo request and reply read from pr until the read
+// deadline passes.
+func logEchoes(pr packetReader, fam family) error {
+	b := make([]byte, *flagRecvBuf)
+	for {
+		n, meta, peer, err := pr.ReadFrom(b)
+		if err != nil {
+			if opErr, ok := err.(*net.OpError); ok && opErr.Timeout() {
+				return nil
+			}
+			return err
+		}
+		rm, err := icmp.ParseMessage(fam.proto, b[:n])
+		if err != nil {
+			continue
+		}
+		echo, ok := rm.Body.(*icmp.Echo)
+		if !ok {
+			continue
+		}
+		var kind string
+		switch rm.Type {
+		case fam.echoRequest:
+			kind = "request"
+		case fam.echoReply:
+			kind = "reply"
+		default:
+			continue
+		}
+		log.Printf("[%s] echo %s to %s: id=%#04x seq=%d ttl=%d, %d data bytes", peer, kind, meta.dst, echo.ID, echo.Seq, meta.ttl, len(echo.Data))
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// A --record file holds one JSON object per line, one for every ICMP
+// message read while waiting for a reply:
+//
+//	{"host":"example.com","ip":"192.0.2.1","id":1234,"seq":1,
+//	 "payload":"<base64>","nonce":"<base64>","sent":"<RFC 3339>",
+//	 "recv":"<RFC 3339>","ttl":57,"dst":"192.0.2.2","peer":"192.0.2.1",
+//	 "packet":"<base64>"}
+//
+// host, ip, id, seq, payload, nonce and sent describe the request being
+// waited for; the rest describe the message, where packet is the ICMP
+// message itself, without an IP header. nonce is only present with
+// --match-nonce, and ttl and dst only if the platform reported them.
+//
+// A request that times out ends with an entry that has "timeout":true and
+// no message, recv being when the wait gave up.
+type recordEntry struct {
+	Host    string    `json:"host"`
+	IP      string    `json:"ip"`
+	ID      int       `json:"id"`
+	Seq     int       `json:"seq"`
+	Payload []byte    `json:"payload"`
+	Nonce   []byte    `json:"nonce,omitempty"`
+	Sent    time.Time `json:"sent"`
+	Recv    time.Time `json:"recv"`
+	TTL     int       `json:"ttl,omitempty"`
+	Dst     string    `json:"dst,omitempty"`
+	Peer    string    `json:"peer"`
+	Packet  []byte    `json:"packet,omitempty"`
+	Timeout bool      `json:"timeout,omitempty"`
+}
+
+var (
+	recordMu  sync.Mutex
+	recordEnc *json.Encoder
+)
+
+// openRecord opens path for appending --record entries.
+func openRecord(path string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	recordEnc = json.NewEncoder(f)
+	return nil
+}
+
+// recordPacket appends a message read while waiting for req, rtt after it
+// was sent, to the --record file, if any.
+func recordPacket(req *request, meta replyMeta, peer net.Addr, b []byte, rtt time.Duration) {
+	if recordEnc == nil {
+		return
+	}
+	e := recordEntry{
+		Host:    req.addr,
+		IP:      req.resolved.IP.String(),
+		ID:      req.id,
+		Seq:     req.seq,
+		Payload: req.payload,
+		Nonce:   req.nonce,
+		Sent:    req.start,
+		Recv:    req.start.Add(rtt),
+		TTL:     meta.ttl,
+		Packet:  b,
+	}
+	if meta.dst != nil {
+		e.Dst = meta.dst.String()
+	}
+	if peer != nil {
+		e.Peer = peer.String()
+	}
+	writeRecord(e)
+}
+
+// recordTimeout appends the end of the wait for req, which timed out rtt
+// after it was sent, to the --record file, if any.
+func recordTimeout(req *request, rtt time.Duration) {
+	if recordEnc == nil {
+		return
+	}
+	writeRecord(recordEntry{
+		Host:    req.addr,
+		IP:      req.resolved.IP.String(),
+		ID:      req.id,
+		Seq:     req.seq,
+		Payload: req.payload,
+		Nonce:   req.nonce,
+		Sent:    req.start,
+		Recv:    req.start.Add(rtt),
+		Timeout: true,
+	})
+}
+
+func writeRecord(e recordEntry) {
+	recordMu.Lock()
+	defer recordMu.Unlock()
+	recordEnc.Encode(e)
+}
+
+// replayReader feeds the recorded messages for one request to readReply,
+// then reports a timeout when it was recorded, or as if --timeout had
+// passed since the request was sent.
+type replayReader struct {
+	req     *request
+	entries []recordEntry
+}
+
+func (r *replayReader) ReadFrom(b []byte) (int, replyMeta, net.Addr, error) {
+	if len(r.entries) == 0 {
+		meta := replyMeta{recv: r.req.start.Add(*flagTimeout)}
+		return 0, meta, nil, &net.OpError{Op: "read", Net: "replay", Err: os.ErrDeadlineExceeded}
+	}
+	e := r.entries[0]
+	r.entries = r.entries[1:]
+	if e.Timeout {
+		r.entries = nil
+		return 0, replyMeta{recv: e.Recv}, nil, &net.OpError{Op: "read", Net: "replay", Err: os.ErrDeadlineExceeded}
+	}
+	n := copy(b, e.Packet)
+	meta := replyMeta{ttl: e.TTL, dst: net.ParseIP(e.Dst), recv: e.Recv}
+	return n, meta, &net.IPAddr{IP: net.ParseIP(e.Peer)}, nil
+}
+
+// replay re-reports the requests recorded in path without touching the
+// network, for --replay.
+func replay(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// Group the messages by request, in the order requests first appear.
+	type key struct {
+		host, ip string
+		id, seq  int
+		sent     time.Time
+	}
+	var order []key
+	groups := make(map[key][]recordEntry)
+	sc := bufio.NewScanner(f)
+	sc.Buffer(nil, 1<<20)
+	for line := 1; sc.Scan(); line++ {
+		var e recordEntry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			return fmt.Errorf("%s:%d: %v", path, line, err)
+		}
+		k := key{e.Host, e.IP, e.ID, e.Seq, e.Sent}
+		if _, ok := groups[k]; !ok {
+			order = append(order, k)
+		}
+		groups[k] = append(groups[k], e)
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+
+	for _, k := range order {
+		first := groups[k][0]
+		ip := net.ParseIP(first.IP)
+		if ip == nil {
+			return fmt.Errorf("%s: invalid address %q", path, first.IP)
+		}
+		fam := familyIPv6
+		if ip4 := ip.To4(); ip4 != nil {
+			ip, fam = ip4, familyIPv4
+		}
+		req := &request{
+			addr:     first.Host,
+			resolved: &net.IPAddr{IP: ip},
+			id:       first.ID,
+			seq:      first.Seq,
+			nonce:    first.Nonce,
+			payload:  first.Payload,
+			start:    first.Sent,
+		}
+		noteSent(req)
+		hostEntry(req.addr)
+		r, err := readReply(&replayReader{req: req, entries: groups[k]}, fam, req)
+		if err != nil {
+			log.Printf("[%s] error: %v", req.addr, err)
+			continue
+		}
+		handleResult(r)
+	}
+	return nil
+}
@@ -0,0 +1,578 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// family holds the protocol number and message types that differ between
+// ICMP and ICMPv6.
+type family struct {
+	proto        int
+	echoRequest  icmp.Type
+	echoReply    icmp.Type
+	unreachable  icmp.Type
+	timeExceeded icmp.Type
+	redirect     icmp.Type
+}
+
+var (
+	familyIPv4 = family{
+		proto:        ProtocolICMP,
+		echoRequest:  ipv4.ICMPTypeEcho,
+		echoReply:    ipv4.ICMPTypeEchoReply,
+		unreachable:  ipv4.ICMPTypeDestinationUnreachable,
+		timeExceeded: ipv4.ICMPTypeTimeExceeded,
+		redirect:     ipv4.ICMPTypeRedirect,
+	}
+	familyIPv6 = family{
+		proto:        ProtocolIPv6ICMP,
+		echoRequest:  ipv6.ICMPTypeEchoRequest,
+		echoReply:    ipv6.ICMPTypeEchoReply,
+		unreachable:  ipv6.ICMPTypeDestinationUnreachable,
+		timeExceeded: ipv6.ICMPTypeTimeExceeded,
+		redirect:     ipv6.ICMPTypeRedirect,
+	}
+)
+
+// packetReader is the source of incoming ICMP messages. It is satisfied by
+// thin wrappers around the real sockets, and lets the reply matching logic
+// be fed synthetic packets.
+type packetReader interface {
+	// ReadFrom reads a single ICMP message into b, returning its length,
+	// the metadata available from control messages, and the sender.
+	ReadFrom(b []byte) (n int, meta replyMeta, peer net.Addr, err error)
+}
+
+// replyMeta is the per-packet information taken from control messages. Any
+// field may be zero if the platform does not report it.
+type replyMeta struct {
+	ttl     int       // TTL or hop limit
+	dst     net.IP    // local address the packet was sent to
+	ifIndex int       // index of the interface the packet arrived on
+	recv    time.Time // kernel receive timestamp, with --kernel-timestamps
+}
+
+type ipv4Reader struct{ c *ipv4.PacketConn }
+
+func (r ipv4Reader) ReadFrom(b []byte) (int, replyMeta, net.Addr, error) {
+	n, cm, peer, err := r.c.ReadFrom(b)
+	if cm == nil {
+		return n, replyMeta{}, peer, err
+	}
+	return n, replyMeta{ttl: cm.TTL, dst: cm.Dst, ifIndex: cm.IfIndex}, peer, err
+}
+
+type ipv6Reader struct{ c *ipv6.PacketConn }
+
+func (r ipv6Reader) ReadFrom(b []byte) (int, replyMeta, net.Addr, error) {
+	n, cm, peer, err := r.c.ReadFrom(b)
+	if cm == nil {
+		return n, replyMeta{}, peer, err
+	}
+	return n, replyMeta{ttl: cm.HopLimit, dst: cm.Dst, ifIndex: cm.IfIndex}, peer, err
+}
+
+// timestampReader reads directly from the raw socket so that it can see
+// the kernel receive timestamp, which the ipv4 and ipv6 packages drop.
+type timestampReader struct {
+	c   *net.IPConn
+	fam family
+	oob []byte
+	buf []byte // for IPv4, room for the message and a maximal IP header
+}
+
+var timestampFallbackOnce sync.Once
+
+// newTimestampReader enables kernel receive timestamps on c and returns a
+// reader that uses them. If they are unavailable it logs a warning, once,
+// and returns nil so that the caller falls back to userspace timing.
+func newTimestampReader(c net.PacketConn, fam family) packetReader {
+	ipc, ok := c.(*net.IPConn)
+	err := errKernelTimestampsUnsupported
+	if ok {
+		err = enableKernelTimestamps(ipc)
+	}
+	if err != nil {
+		timestampFallbackOnce.Do(func() {
+			log.Printf("warning: kernel timestamps unavailable, using userspace timing: %v", err)
+		})
+		return nil
+	}
+	return &timestampReader{c: ipc, fam: fam, oob: make([]byte, 512)}
+}
+
+func (r *timestampReader) ReadFrom(b []byte) (int, replyMeta, net.Addr, error) {
+	if r.fam.proto != ProtocolICMP {
+		n, oobn, _, peer, err := r.c.ReadMsgIP(b, r.oob)
+		if err != nil {
+			return 0, replyMeta{}, nil, err
+		}
+		return n, r.meta(oobn), peer, nil
+	}
+
+	// Unlike ReadFrom, ReadMsgIP leaves the IPv4 header in place, so read
+	// into a buffer with room for it. A message that does not fit in b
+	// once the header is stripped still fills b, so that callers see it
+	// as truncated.
+	if len(r.buf) != len(b)+maxIPv4HeaderLen {
+		r.buf = make([]byte, len(b)+maxIPv4HeaderLen)
+	}
+	n, oobn, _, peer, err := r.c.ReadMsgIP(r.buf, r.oob)
+	if err != nil {
+		return 0, replyMeta{}, nil, err
+	}
+	if n > 0 {
+		hl := int(r.buf[0]&0x0f) << 2
+		if hl > n {
+			return 0, replyMeta{}, nil, fmt.Errorf("short IPv4 packet of %d bytes", n)
+		}
+		n = copy(b, r.buf[hl:n])
+	}
+	return n, r.meta(oobn), peer, nil
+}
+
+// maxIPv4HeaderLen is the length of an IPv4 header with the most options.
+const maxIPv4HeaderLen = 60
+
+// meta parses the oobn bytes of control messages read with a packet.
+func (r *timestampReader) meta(oobn int) replyMeta {
+	var meta replyMeta
+	oob := r.oob[:oobn]
+	if r.fam.proto == ProtocolICMP {
+		var cm ipv4.ControlMessage
+		if cm.Parse(oob) == nil {
+			meta.ttl, meta.dst, meta.ifIndex = cm.TTL, cm.Dst, cm.IfIndex
+		}
+	} else {
+		var cm ipv6.ControlMessage
+		if cm.Parse(oob) == nil {
+			meta.ttl, meta.dst, meta.ifIndex = cm.HopLimit, cm.Dst, cm.IfIndex
+		}
+	}
+	meta.recv, _ = parseKernelTimestamp(oob)
+	return meta
+}
+
+// request describes a single echo request that has been or is about to be
+// sent.
+type request struct {
+	addr     string      // target as given on the command line
+	resolved *net.IPAddr // address the request is sent to
+	id, seq  int         // ICMP identifier and sequence number
+	nonce    []byte      // random suffix of the payload, with --match-nonce
+	payload  []byte      // echo data: the --data bytes followed by any nonce
+	start    time.Time   // when the request was sent
+}
+
+// newRequest returns request seq to send to resolved, generating a nonce if
+// --match-nonce is set.
+func newRequest(addr string, resolved *net.IPAddr, seq int) *request {
+	req := &request{addr: addr, resolved: resolved, id: hostID(addr, resolved.IP.To4() == nil), seq: seq}
+	if *flagMatchNonce {
+		req.nonce = make([]byte, nonceLen)
+		if _, err := rand.Read(req.nonce); err != nil {
+			panic(err)
+		}
+	}
+	data := *flagData
+	if *flagIPutilsPayload {
+		data = iputilsPayload(clock.Now())
+	}
+	req.payload = append(append([]byte{}, data...), req.nonce...)
+	noteSent(req)
+	return req
+}
+
+// iputilsDataLen is the default data length of iputils ping.
+const iputilsDataLen = 56
+
+// iputilsPayload returns request data laid out as iputils ping sends it by
+// default: a struct timeval of the send time, as on 64-bit little-endian
+// Linux (tv_sec and tv_usec as 8-byte integers), then each remaining byte
+// set to its offset in the data. Wireshark decodes the timeval as the
+// "timestamp from icmp data".
+func iputilsPayload(now time.Time) []byte {
+	b := make([]byte, iputilsDataLen)
+	for i := range b {
+		b[i] = byte(i)
+	}
+	binary.LittleEndian.PutUint64(b[0:8], uint64(now.Unix()))
+	binary.LittleEndian.PutUint64(b[8:16], uint64(now.Nanosecond()/1000))
+	return b
+}
+
+// nonceLen is the size of the nonce appended to the payload.
+const nonceLen = 8
+
+// maxPayload is the largest echo payload that fits in an IPv4 packet.
+const maxPayload = 65535 - ipv4.HeaderLen - 8
+
+// maxData returns the largest --data or --payload-file that fits in an
+// echo request, leaving room for the nonce with --match-nonce.
+func maxData() int {
+	if *flagMatchNonce {
+		return maxPayload - nonceLen
+	}
+	return maxPayload
+}
+
+// checkMTU warns if requests to ip are larger than the MTU of the interface
+// they will be sent from, and so will be fragmented. Only requests larger
+// than the IPv6 minimum MTU are checked, to avoid a route lookup for every
+// ordinary ping.
+func checkMTU(addr string, ip net.IP) {
+	size := ipv6.HeaderLen + 8 + len(*flagData)
+	if ip.To4() != nil {
+		size = ipv4.HeaderLen + 8 + len(*flagData)
+	}
+	if *flagMatchNonce {
+		size += nonceLen
+	}
+	if size <= 1280 {
+		return
+	}
+	ifi, err := routeInterface(ip)
+	if err != nil || ifi == nil {
+		return
+	}
+	if size > ifi.MTU {
+		log.Printf("[%s] %s: warning: %d byte request exceeds the %d byte MTU of %s and will be fragmented (largest unfragmented data: %d bytes)", addr, ip, size, ifi.MTU, ifi.Name, ifi.MTU-(size-len(*flagData)))
+	}
+}
+
+// result returns a result for req with the given status and RTT.
+func (req *request) result(status string, rtt time.Duration) result {
+	return result{Host: req.addr, IP: req.resolved.IP, ID: req.id, Seq: req.seq, RTT: rtt, Status: status, Tag: *flagTag, SentBytes: 8 + len(req.payload)}
+}
+
+// sendFailure returns the result for req when sending it failed with err.
+// Failures the kernel reports for the route or the local firewall become
+// timeouts with a send-failed reason, since the host was never reached;
+// anything else is returned as an error.
+func sendFailure(req *request, err error) (result, error) {
+	var errno syscall.Errno
+	if !errors.As(err, &errno) {
+		return result{}, err
+	}
+	switch errno {
+	case syscall.EMSGSIZE:
+		return result{}, fmt.Errorf("%d byte request is too large to send: %v", 8+len(req.payload), err)
+	case syscall.EHOSTUNREACH, syscall.ENETUNREACH, syscall.EHOSTDOWN, syscall.ENOBUFS, syscall.EPERM, syscall.EACCES:
+		r := req.result(statusTimeout, 0)
+		r.Reason = reasonSendFailed + ": " + errno.Error()
+		r.SentBytes = 0
+		return r, nil
+	}
+	return result{}, err
+}
+
+// readReply reads from pr until it sees the reply to req, or until the read
+// deadline passes.
+func readReply(pr packetReader, fam family, req *request) (result, error) {
+	reply := make([]byte, *flagRecvBuf)
+	var sawTruncated bool
+	var truncatedRTT time.Duration // when the first truncated packet arrived
+	for {
+		n, meta, peer, err := pr.ReadFrom(reply)
+		duration := clock.Now().Sub(req.start)
+		if !meta.recv.IsZero() {
+			duration = meta.recv.Sub(req.start)
+		}
+		if err != nil {
+			if opErr, ok := err.(*net.OpError); ok {
+				if opErr.Timeout() {
+					recordTimeout(req, duration)
+				}
+				if opErr.Timeout() && sawTruncated {
+					return req.result(statusTruncated, truncatedRTT), nil
+				}
+				if opErr.Timeout() {
+					r := req.result(statusTimeout, duration)
+					r.Reason = reasonNoReply
+					return r, nil
+				}
+			}
+			return result{}, err
+		}
+		recordPacket(req, meta, peer, reply[:n], duration)
+
+		// Datagrams larger than the buffer are cut short, and the rest
+		// cannot be read back.
+		truncated := n == len(reply)
+		if truncated {
+			log.Printf("[%s] %s: warning: reply truncated (got %d bytes, buffer full); raise --recv-buffer-size", req.addr, req.resolved.IP, n)
+		}
+
+		if *flagDumpRaw {
+			dumpRaw("received", req, reply[:n])
+		}
+
+		rm, err := icmp.ParseMessage(fam.proto, reply[:n])
+		if err != nil {
+			if truncated {
+				// It may not even be the reply, so keep reading; if
+				// nothing else arrives, report the truncation rather
+				// than a timeout.
+				if !sawTruncated {
+					sawTruncated, truncatedRTT = true, duration
+				}
+				continue
+			}
+			return result{}, err
+		}
+		switch rm.Type {
+		case fam.echoReply:
+			if !matchEcho(rm.Body, peer, req) {
+				continue
+			}
+			if fakeLoss() {
+				// Keep reading, so the request times out as if the
+				// reply had never arrived.
+				continue
+			}
+			if got := len(rm.Body.(*icmp.Echo).Data); got != len(req.payload) {
+				how := "padded"
+				if got < len(req.payload) {
+					how = "truncated"
+				}
+				log.Printf("[%s] %s: warning: reply payload %s: got %d bytes, sent %d", req.addr, req.resolved.IP, how, got, len(req.payload))
+			}
+			checkFragmented(fam, req, n, meta)
+			r := req.result(statusReply, duration)
+			r.ReceivedBytes = n
+			r.TTL = meta.ttl
+			r.Src = meta.dst
+			r.Peer = peerIP(peer)
+			if id := rm.Body.(*icmp.Echo).ID; id != req.id {
+				// Only possible with --match-nonce, which matched the
+				// reply despite the different ID.
+				r.ReplyID, r.IDRewritten = id, true
+				logIDRewrite(req, id)
+			}
+			return r, nil
+
+		case fam.echoRequest:
+			// Our own request seen on loopback, or someone pinging us.
+			continue
+
+		case fam.unreachable, fam.timeExceeded:
+			// Raw sockets see every ICMP error on the host, so use the
+			// quoted original datagram to tell whether this one is
+			// about our request. Errors quoting too little to tell
+			// are assumed to be ours.
+			q, quoted := parseQuoted(fam, errorData(rm.Body))
+			if quoted && !q.matches(req) {
+				continue
+			}
+			status := statusUnreachable
+			if rm.Type == fam.timeExceeded {
+				status = statusTimeExceeded
+			}
+			r := req.result(status, duration)
+			r.Quoted = quoted
+			r.MPLS = mplsLabels(rm.Body)
+			return r, nil
+
+		case fam.redirect:
+			// A redirect is advisory; the request was still forwarded, so
+			// keep waiting for the reply.
+			logRedirect(fam, req, rm.Body, peer)
+			continue
+
+		default:
+			// Raw sockets also see unrelated ICMP, such as neighbor
+			// discovery, so only messages from the target count.
+			if !peerIP(peer).Equal(req.resolved.IP) {
+				continue
+			}
+			switch *flagStrictReplyType {
+			case "error":
+				return result{}, fmt.Errorf("got %+v from %v; want echo reply", rm, peer)
+			case "loss":
+				// Keep reading, so the request times out unless the
+				// real reply still arrives.
+				continue
+			}
+			log.Printf("[%s] %s: warning: unexpected %v reply from %v", req.addr, req.resolved.IP, rm.Type, peer)
+			return req.result(statusOther, duration), nil
+		}
+	}
+}
+
+var idCollisionOnce sync.Once
+
+// sentKey identifies an echo request sent by this process.
+type sentKey struct {
+	id, seq int
+	ip      string
+}
+
+var (
+	sentMu       sync.Mutex
+	sentRequests = make(map[sentKey]bool)
+)
+
+// noteSent remembers that req was sent by this process, so that a reply to
+// it is never mistaken for one to another process using the same ID.
+func noteSent(req *request) {
+	sentMu.Lock()
+	defer sentMu.Unlock()
+	sentRequests[sentKey{req.id, req.seq, req.resolved.IP.String()}] = true
+}
+
+// sentByUs reports whether this process sent an echo request with the
+// given ID and sequence number to ip.
+func sentByUs(id, seq int, ip net.IP) bool {
+	sentMu.Lock()
+	defer sentMu.Unlock()
+	return sentRequests[sentKey{id, seq, ip.String()}]
+}
+
+var (
+	idRewriteMu     sync.Mutex
+	idRewriteLogged = make(map[string]bool)
+)
+
+// logIDRewrite reports, once per host, that the reply to req came back
+// with ICMP ID got, which usually means a NAT rewrote the ID.
+func logIDRewrite(req *request, got int) {
+	idRewriteMu.Lock()
+	defer idRewriteMu.Unlock()
+	if idRewriteLogged[req.addr] {
+		return
+	}
+	idRewriteLogged[req.addr] = true
+	log.Printf("[%s] %s: ICMP ID was rewritten (NAT detected): sent %d, got %d", req.addr, req.resolved.IP, req.id, got)
+}
+
+// matchEcho reports whether body, received from peer, is an echo reply to
+// req. Raw ICMP sockets receive every reply on the host, including those to
+// the other addresses being pinged with the same ID, so the reply must come
+// from the address req was sent to.
+//
+// With --match-nonce, replies are also matched on the random payload
+// suffix alone, which stays unambiguous even when IDs collide. Otherwise
+// they are matched on ID and sequence number. A reply carrying our ID but a
+// sequence number or source this process never sent usually means another
+// process is using the same ID; the first one seen is logged.
+func matchEcho(body icmp.MessageBody, peer net.Addr, req *request) bool {
+	echo, ok := body.(*icmp.Echo)
+	if !ok {
+		return false
+	}
+	src := peerIP(peer)
+	if req.nonce != nil {
+		return src.Equal(req.resolved.IP) && bytes.HasSuffix(echo.Data, req.nonce)
+	}
+	if echo.ID != req.id {
+		return false
+	}
+	if echo.Seq == req.seq && src.Equal(req.resolved.IP) {
+		return true
+	}
+	if !sentByUs(echo.ID, echo.Seq, src) {
+		idCollisionOnce.Do(func() {
+			log.Printf("warning: ignoring echo reply from %v with our ID %d and seq %d, which we never sent; another process may be using the same ID", peer, echo.ID, echo.Seq)
+		})
+	}
+	return false
+}
+
+// peerIP returns the IP address of peer, or nil if it has none.
+func peerIP(peer net.Addr) net.IP {
+	switch a := peer.(type) {
+	case *net.IPAddr:
+		return a.IP
+	case *net.UDPAddr:
+		return a.IP
+	}
+	return nil
+}
+
+// checkFragmented logs a note if the reply to req, n bytes of ICMP, must
+// have arrived in fragments. The kernel reassembles fragments before raw
+// sockets see them, so this is inferred from the reassembled packet being
+// larger than the MTU of the interface it arrived on. IP options and IPv6
+// extension headers are not counted.
+func checkFragmented(fam family, req *request, n int, meta replyMeta) {
+	if meta.ifIndex == 0 {
+		return
+	}
+	ifi, err := net.InterfaceByIndex(meta.ifIndex)
+	if err != nil {
+		return
+	}
+	size := n + ipv4.HeaderLen
+	if fam.proto == ProtocolIPv6ICMP {
+		size = n + ipv6.HeaderLen
+	}
+	if size > ifi.MTU {
+		log.Printf("[%s] %s: reply was fragmented: %d byte packet exceeds the %d byte MTU of %s", req.addr, req.resolved.IP, size, ifi.MTU, ifi.Name)
+	}
+}
+
+// logRedirect reports a redirect received while waiting for the reply to
+// req, if it refers to req.
+func logRedirect(fam family, req *request, body icmp.MessageBody, peer net.Addr) {
+	raw, ok := body.(*icmp.RawBody)
+	if !ok {
+		return
+	}
+	gateway, quoted, ok := parseRedirect(fam, raw.Data)
+	if !ok {
+		return
+	}
+	if fam.proto == ProtocolICMP || len(quoted) > 0 {
+		if q, ok := parseQuoted(fam, quoted); !ok || !q.matches(req) {
+			return
+		}
+	} else if dst := net.IP(raw.Data[20:36]); !dst.Equal(req.resolved.IP) {
+		// No Redirected Header option, so match on the IPv6 redirect's
+		// destination address instead.
+		return
+	}
+	log.Printf("[%s] %s: redirect from %v: use gateway %s", req.addr, req.resolved.IP, peer, gateway)
+}
+
+// errorData returns the original datagram quoted in an ICMP error body.
+func errorData(body icmp.MessageBody) []byte {
+	switch b := body.(type) {
+	case *icmp.DstUnreach:
+		return b.Data
+	case *icmp.TimeExceeded:
+		return b.Data
+	}
+	return nil
+}
+
+// mplsLabels returns any MPLS label stack entries carried in the RFC 4884
+// extension structure of an ICMP error message.
+func mplsLabels(body icmp.MessageBody) []icmp.MPLSLabel {
+	var exts []icmp.Extension
+	switch b := body.(type) {
+	case *icmp.DstUnreach:
+		exts = b.Extensions
+	case *icmp.TimeExceeded:
+		exts = b.Extensions
+	}
+	var labels []icmp.MPLSLabel
+	for _, ext := range exts {
+		if ls, ok := ext.(*icmp.MPLSLabelStack); ok {
+			labels = append(labels, ls.Labels...)
+		}
+	}
+	return labels
+}
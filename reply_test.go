@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+)
+
+// fakePacket is one message returned by a fakeReader.
+type fakePacket struct {
+	b    []byte
+	peer string
+	recv time.Time
+	ttl  int
+}
+
+// fakeReader returns its packets in order, then a timeout at timeout.
+type fakeReader struct {
+	packets []fakePacket
+	timeout time.Time
+}
+
+func (r *fakeReader) ReadFrom(b []byte) (int, replyMeta, net.Addr, error) {
+	if len(r.packets) == 0 {
+		return 0, replyMeta{recv: r.timeout}, nil, &net.OpError{Op: "read", Net: "fake", Err: os.ErrDeadlineExceeded}
+	}
+	p := r.packets[0]
+	r.packets = r.packets[1:]
+	return copy(b, p.b), replyMeta{ttl: p.ttl, recv: p.recv}, &net.IPAddr{IP: net.ParseIP(p.peer)}, nil
+}
+
+// testRequest returns a request to ip sent at start, remembered as sent.
+func testRequest(ip string, id, seq int, start time.Time) *request {
+	req := &request{addr: ip, resolved: &net.IPAddr{IP: net.ParseIP(ip).To4()}, id: id, seq: seq, start: start}
+	noteSent(req)
+	return req
+}
+
+// marshal returns the wire form of an ICMP message of type typ.
+func marshal(t *testing.T, typ icmp.Type, body icmp.MessageBody) []byte {
+	t.Helper()
+	b, err := (&icmp.Message{Type: typ, Body: body}).Marshal(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func echoReply(t *testing.T, id, seq int) []byte {
+	return marshal(t, ipv4.ICMPTypeEchoReply, &icmp.Echo{ID: id, Seq: seq})
+}
+
+// TestTwoIDsOnOneSocket checks that replies carrying another process's ID,
+// or our ID but sent by another of the addresses being pinged, are skipped.
+func TestTwoIDsOnOneSocket(t *testing.T) {
+	start := time.Unix(1000, 0)
+	req := testRequest("192.0.2.1", 0x1234, 1, start)
+	testRequest("192.0.2.2", 0x1234, 1, start)
+
+	pr := &fakeReader{
+		packets: []fakePacket{
+			{b: echoReply(t, 0x4321, 1), peer: "192.0.2.1", recv: start.Add(1 * time.Millisecond)},
+			{b: echoReply(t, 0x1234, 1), peer: "192.0.2.2", recv: start.Add(2 * time.Millisecond)},
+			{b: echoReply(t, 0x1234, 1), peer: "192.0.2.1", recv: start.Add(3 * time.Millisecond)},
+		},
+		timeout: start.Add(time.Second),
+	}
+	r, err := readReply(pr, familyIPv4, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Status != statusReply || r.RTT != 3*time.Millisecond {
+		t.Errorf("got %s in %s; want reply in 3ms", r.Status, r.RTT)
+	}
+
+	// A neighbour's reply alone must not complete the request.
+	pr = &fakeReader{
+		packets: []fakePacket{{b: echoReply(t, 0x1234, 1), peer: "192.0.2.2", recv: start.Add(time.Millisecond)}},
+		timeout: start.Add(time.Second),
+	}
+	r, err = readReply(pr, familyIPv4, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Status != statusTimeout {
+		t.Errorf("got %s; want %s", r.Status, statusTimeout)
+	}
+}
+
+// TestUnrelatedICMPIgnored checks that an unexpected ICMP type from
+// another host, such as neighbor discovery, does not end the wait.
+func TestUnrelatedICMPIgnored(t *testing.T) {
+	start := time.Unix(1000, 0)
+	req := testRequest("192.0.2.1", 0x1234, 1, start)
+	pr := &fakeReader{
+		packets: []fakePacket{
+			{b: marshal(t, ipv4.ICMPTypeTimestamp, &icmp.RawBody{Data: make([]byte, 16)}), peer: "192.0.2.9", recv: start.Add(time.Millisecond)},
+			{b: echoReply(t, 0x1234, 1), peer: "192.0.2.1", recv: start.Add(2 * time.Millisecond)},
+		},
+		timeout: start.Add(time.Second),
+	}
+	r, err := readReply(pr, familyIPv4, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Status != statusReply {
+		t.Errorf("got %s; want %s", r.Status, statusReply)
+	}
+}
+
+// quotedRequest returns an IPv4 header addressed to dst followed by the
+// first 8 bytes of an echo request, as quoted in an ICMP error.
+func quotedRequest(dst string, id, seq int) []byte {
+	b := make([]byte, ipv4.HeaderLen+8)
+	b[0] = 0x45
+	b[9] = ProtocolICMP
+	copy(b[16:20], net.ParseIP(dst).To4())
+	b[ipv4.HeaderLen] = byte(ipv4.ICMPTypeEcho)
+	binary.BigEndian.PutUint16(b[ipv4.HeaderLen+4:], uint16(id))
+	binary.BigEndian.PutUint16(b[ipv4.HeaderLen+6:], uint16(seq))
+	return b
+}
+
+func TestReadReply(t *testing.T) {
+	start := time.Unix(1000, 0)
+	at := func(ms int) time.Time { return start.Add(time.Duration(ms) * time.Millisecond) }
+	unreach := func(dst string, id, seq int) []byte {
+		return marshal(t, ipv4.ICMPTypeDestinationUnreachable, &icmp.DstUnreach{Data: quotedRequest(dst, id, seq)})
+	}
+
+	tests := []struct {
+		name    string
+		packets []fakePacket
+		status  string
+		rtt     time.Duration
+		ttl     int
+		quoted  bool
+	}{
+		{
+			name:    "match",
+			packets: []fakePacket{{b: echoReply(t, 0x1234, 1), peer: "192.0.2.1", recv: at(5), ttl: 57}},
+			status:  statusReply, rtt: 5 * time.Millisecond, ttl: 57,
+		},
+		{
+			name: "foreign ID",
+			packets: []fakePacket{
+				{b: echoReply(t, 0x9999, 1), peer: "192.0.2.1", recv: at(1)},
+				{b: echoReply(t, 0x1234, 1), peer: "192.0.2.1", recv: at(7)},
+			},
+			status: statusReply, rtt: 7 * time.Millisecond,
+		},
+		{
+			name:    "stale seq",
+			packets: []fakePacket{{b: echoReply(t, 0x1234, 2), peer: "192.0.2.1", recv: at(1)}},
+			status:  statusTimeout, rtt: time.Second,
+		},
+		{
+			name:   "timeout",
+			status: statusTimeout, rtt: time.Second,
+		},
+		{
+			name:    "quoted unreachable",
+			packets: []fakePacket{{b: unreach("192.0.2.1", 0x1234, 1), peer: "198.51.100.1", recv: at(3)}},
+			status:  statusUnreachable, rtt: 3 * time.Millisecond, quoted: true,
+		},
+		{
+			name:    "quoted unreachable for another request",
+			packets: []fakePacket{{b: unreach("192.0.2.2", 0x1234, 1), peer: "198.51.100.1", recv: at(3)}},
+			status:  statusTimeout, rtt: time.Second,
+		},
+		{
+			name: "unquoted unreachable",
+			packets: []fakePacket{{
+				b:    marshal(t, ipv4.ICMPTypeDestinationUnreachable, &icmp.DstUnreach{Data: []byte{0x45}}),
+				peer: "198.51.100.1", recv: at(4),
+			}},
+			status: statusUnreachable, rtt: 4 * time.Millisecond,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := testRequest("192.0.2.1", 0x1234, 1, start)
+			r, err := readReply(&fakeReader{packets: tt.packets, timeout: at(1000)}, familyIPv4, req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if r.Status != tt.status || r.RTT != tt.rtt || r.TTL != tt.ttl || r.Quoted != tt.quoted {
+				t.Errorf("got %s in %s (ttl %d, quoted %t); want %s in %s (ttl %d, quoted %t)",
+					r.Status, r.RTT, r.TTL, r.Quoted, tt.status, tt.rtt, tt.ttl, tt.quoted)
+			}
+		})
+	}
+}
+
+// TestReadReplyTruncated checks that a message filling the whole buffer,
+// which cannot be parsed, is reported as truncated once the wait ends.
+func TestReadReplyTruncated(t *testing.T) {
+	defer func(n int) { *flagRecvBuf = n }(*flagRecvBuf)
+	*flagRecvBuf = 3
+
+	start := time.Unix(1000, 0)
+	req := testRequest("192.0.2.1", 0x1234, 1, start)
+	pr := &fakeReader{
+		packets: []fakePacket{{b: echoReply(t, 0x1234, 1), peer: "192.0.2.1", recv: start.Add(2 * time.Millisecond)}},
+		timeout: start.Add(time.Second),
+	}
+	r, err := readReply(pr, familyIPv4, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Status != statusTruncated || r.RTT != 2*time.Millisecond {
+		t.Errorf("got %s in %s; want %s in 2ms", r.Status, r.RTT, statusTruncated)
+	}
+}
+
+// TestIDCollisionWarning checks that a reply with our ID but a sequence
+// number we never sent is warned about, once.
+func TestIDCollisionWarning(t *testing.T) {
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+	idCollisionOnce = sync.Once{}
+
+	start := time.Unix(1000, 0)
+	for seq := 1; seq <= 2; seq++ {
+		req := testRequest("192.0.2.1", 0x5678, seq, start)
+		pr := &fakeReader{
+			packets: []fakePacket{{b: echoReply(t, 0x5678, 100+seq), peer: "192.0.2.1", recv: start.Add(time.Millisecond)}},
+			timeout: start.Add(time.Second),
+		}
+		r, err := readReply(pr, familyIPv4, req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.Status != statusTimeout {
+			t.Errorf("got %s; want %s", r.Status, statusTimeout)
+		}
+	}
+	if n := strings.Count(logs.String(), "another process may be using the same ID"); n != 1 {
+		t.Errorf("got %d collision warnings; want 1\n%s", n, logs.String())
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"path/filepath"
+	"text/template"
+)
+
+// reportTemplate is the parsed --report-template, if any.
+var reportTemplate *template.Template
+
+// reportData is the data a --report-template is executed against.
+type reportData struct {
+	Hosts     []reportHost  // every target, in command-line order
+	Summaries []addrSummary // per-address summaries, as in --summary-json-file
+	Total     int           // number of targets
+	Up        int           // targets with at least one reply
+	Down      int           // targets without any reply
+}
+
+// reportHost is one target in reportData.
+type reportHost struct {
+	Host    string
+	Up      bool
+	Results []result
+}
+
+// parseReportTemplate parses the template file at path. Templates can
+// format durations with the rtt function, which honours --rtt-unit.
+func parseReportTemplate(path string) (*template.Template, error) {
+	funcs := template.FuncMap{"rtt": formatRTT}
+	return template.New(filepath.Base(path)).Funcs(funcs).ParseFiles(path)
+}
+
+// writeReport executes reportTemplate against the results of every host.
+func writeReport(hosts []*hostResults) error {
+	data := reportData{Summaries: summarize(hosts), Total: len(hosts)}
+	for _, h := range hosts {
+		up := h.up()
+		if up {
+			data.Up++
+		} else {
+			data.Down++
+		}
+		data.Hosts = append(data.Hosts, reportHost{Host: h.host, Up: up, Results: h.results})
+	}
+	return reportTemplate.Execute(stdout, data)
+}
@@ -0,0 +1,58 @@
+package main
+
+import "sync"
+
+// hostResults holds every result for one target given on the command line.
+type hostResults struct {
+	host    string
+	results []result
+}
+
+// up reports whether any address of the host responded.
+func (h *hostResults) up() bool {
+	for _, r := range h.results {
+		if r.responded() {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	resultsMu sync.Mutex
+	hosts     []*hostResults // in command-line order
+	hostIndex = make(map[string]*hostResults)
+)
+
+// hostEntry returns the entry for host, creating it if needed.
+func hostEntry(host string) *hostResults {
+	resultsMu.Lock()
+	defer resultsMu.Unlock()
+	return hostEntryLocked(host)
+}
+
+func hostEntryLocked(host string) *hostResults {
+	h, ok := hostIndex[host]
+	if !ok {
+		h = &hostResults{host: host}
+		hostIndex[host] = h
+		hosts = append(hosts, h)
+	}
+	return h
+}
+
+// record stores r with the other results for its host.
+func record(r result) {
+	resultsMu.Lock()
+	defer resultsMu.Unlock()
+	h := hostEntryLocked(r.Host)
+	h.results = append(h.results, r)
+}
+
+// allHosts returns the results for every host, in command-line order. It
+// must only be called once pinging has finished.
+func allHosts() []*hostResults {
+	resultsMu.Lock()
+	defer resultsMu.Unlock()
+	return hosts
+}
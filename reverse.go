@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// reverseTimeout bounds each reverse lookup done for --reverse or
+// --label-from-ptr, and reverseConcurrency the number in flight at once.
+const (
+	reverseTimeout     = 2 * time.Second
+	reverseConcurrency = 8
+)
+
+// noPTRLabel is the --label-from-ptr label of a replying address without a
+// PTR name, or whose lookup failed.
+const noPTRLabel = "no PTR"
+
+var (
+	reverseMu    sync.Mutex
+	reverseNames = make(map[string]string) // IP address -> PTR name, or ""
+	reverseSem   = make(chan struct{}, reverseConcurrency)
+)
+
+// lookupReverse finds the PTR name of every literal IP address in targets,
+// for --reverse. It is called as hosts reply, so targets that time out are
+// never looked up.
+func lookupReverse(targets []string) {
+	for _, t := range targets {
+		if net.ParseIP(t) != nil {
+			reverseName(t)
+		}
+	}
+}
+
+// reverseName returns the PTR name of the IP address ip, or "" if it has
+// none. Each distinct address is looked up once.
+func reverseName(ip string) string {
+	reverseMu.Lock()
+	name, seen := reverseNames[ip]
+	reverseMu.Unlock()
+	if seen {
+		return name
+	}
+
+	reverseSem <- struct{}{}
+	ctx, cancel := context.WithTimeout(context.Background(), reverseTimeout)
+	names, err := net.DefaultResolver.LookupAddr(ctx, ip)
+	cancel()
+	<-reverseSem
+
+	if err == nil && len(names) > 0 {
+		name = strings.TrimSuffix(names[0], ".")
+	}
+	reverseMu.Lock()
+	reverseNames[ip] = name
+	reverseMu.Unlock()
+	return name
+}
+
+// ptrLabel returns the --label-from-ptr label of the replying address ip.
+func ptrLabel(ip net.IP) string {
+	if name := reverseName(ip.String()); name != "" {
+		return name
+	}
+	return noPTRLabel
+}
+
+// displayName returns how host is labelled in output. A label given by
+// --targets-url follows the host; failing that, with --reverse, a literal
+// IP target that replied is followed by its PTR name, if it has one.
+func displayName(host string) string {
+	if label := targetLabels[host]; label != "" {
+		return host + " (" + label + ")"
+	}
+	if !*flagReverse {
+		return host
+	}
+	reverseMu.Lock()
+	name := reverseNames[host]
+	reverseMu.Unlock()
+	if name == "" {
+		return host
+	}
+	return host + " (" + name + ")"
+}
+
+// displayName returns how the host of r is labelled in output: by its
+// --label-from-ptr label, if it has one, or else as displayName does.
+func (r result) displayName() string {
+	if r.Label != "" {
+		return r.Host + " (" + r.Label + ")"
+	}
+	return displayName(r.Host)
+}
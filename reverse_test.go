@@ -0,0 +1,28 @@
+package main
+
+import (
+	"io"
+	"log"
+	"net"
+	"os"
+	"testing"
+)
+
+// TestNoReverseOnTimeout checks that --reverse never looks up a target
+// that did not reply.
+func TestNoReverseOnTimeout(t *testing.T) {
+	defer func(v bool) { *flagReverse = v }(*flagReverse)
+	*flagReverse = true
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	for _, status := range []string{statusTimeout, statusUnreachable} {
+		report(result{Host: "192.0.2.1", IP: net.ParseIP("192.0.2.1"), Status: status})
+	}
+	reverseMu.Lock()
+	_, looked := reverseNames["192.0.2.1"]
+	reverseMu.Unlock()
+	if looked {
+		t.Error("a target that did not reply was reverse-resolved")
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+)
+
+// selfTest pings the IPv4 and IPv6 loopback addresses through the normal
+// ping path, printing what worked and advice for anything that did not. It
+// returns false if any check failed.
+func selfTest() bool {
+	ok := true
+	for _, target := range []string{"127.0.0.1", "::1"} {
+		r, err := pingIP(target, net.ParseIP(target))
+		switch {
+		case err != nil:
+			ok = false
+			fmt.Printf("FAIL %s: %v\n", target, err)
+			if advice := selfTestAdvice(err); advice != "" {
+				fmt.Printf("     %s\n", advice)
+			}
+		case r.Status != statusReply:
+			ok = false
+			fmt.Printf("FAIL %s: sent a request but got %s after %s\n", target, r.Status, formatRTT(r.RTT))
+			fmt.Printf("     check that loopback is up and that ICMP echo is not disabled or firewalled\n")
+		default:
+			fmt.Printf("ok   %s: got reply in %s\n", target, formatRTT(r.RTT))
+		}
+	}
+	return ok
+}
+
+// selfTestAdvice returns a hint for fixing a common setup problem behind
+// err, or "" if there is none.
+func selfTestAdvice(err error) string {
+	switch {
+	case errors.Is(err, os.ErrPermission):
+		return fmt.Sprintf("raw ICMP sockets need privileges: run as root or grant CAP_NET_RAW (e.g. setcap cap_net_raw+ep %s)", os.Args[0])
+	case errors.Is(err, syscall.EAFNOSUPPORT), errors.Is(err, syscall.EADDRNOTAVAIL):
+		return "this address family appears to be disabled on this host"
+	}
+	return ""
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/net/icmp"
+)
+
+// A --simulate-from-file script scripts one request per line, with blank
+// lines and lines starting with # ignored:
+//
+//	[HOST] IP EVENT
+//
+// EVENT is either a Go duration, after which the echo reply arrives, or
+// "drop", for a request that is never answered. A delay of --timeout or
+// more also times out. HOST defaults to IP. Successive lines for the same
+// host and address are successive sequence numbers:
+//
+//	# two replies and a loss from one router
+//	gw 192.0.2.1 12ms
+//	gw 192.0.2.1 drop
+//	gw 192.0.2.1 150ms
+//	2001:db8::1 3ms
+//
+// Nothing is sent: every "reply" is synthesized, with an RTT of exactly the
+// scripted delay, so the script drives the reporting and summaries alone.
+
+// simEvent is one scripted request.
+type simEvent struct {
+	host  string
+	ip    net.IP
+	delay time.Duration
+	drop  bool
+}
+
+// parseSimulation reads the --simulate-from-file script at path.
+func parseSimulation(path string) ([]simEvent, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var events []simEvent
+	sc := bufio.NewScanner(f)
+	for line := 1; sc.Scan(); line++ {
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) == 2 {
+			fields = append([]string{fields[0]}, fields...)
+		}
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("%s:%d: want [HOST] IP EVENT", path, line)
+		}
+		e := simEvent{host: fields[0], ip: net.ParseIP(fields[1])}
+		if e.ip == nil {
+			return nil, fmt.Errorf("%s:%d: invalid address %q", path, line, fields[1])
+		}
+		if ip4 := e.ip.To4(); ip4 != nil {
+			e.ip = ip4
+		}
+		if fields[2] == "drop" {
+			e.drop = true
+		} else if e.delay, err = time.ParseDuration(fields[2]); err != nil || e.delay < 0 {
+			return nil, fmt.Errorf("%s:%d: invalid event %q: want a delay or drop", path, line, fields[2])
+		}
+		events = append(events, e)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// simReader answers a single request as its simEvent scripts: with an echo
+// reply after the delay, or with a timeout.
+type simReader struct {
+	req  *request
+	fam  family
+	ev   simEvent
+	done bool
+}
+
+func (r *simReader) ReadFrom(b []byte) (int, replyMeta, net.Addr, error) {
+	if r.done || r.ev.drop || r.ev.delay >= *flagTimeout {
+		meta := replyMeta{recv: r.req.start.Add(*flagTimeout)}
+		return 0, meta, nil, &net.OpError{Op: "read", Net: "simulate", Err: os.ErrDeadlineExceeded}
+	}
+	r.done = true
+	m := icmp.Message{
+		Type: r.fam.echoReply,
+		Body: &icmp.Echo{ID: r.req.id, Seq: r.req.seq, Data: r.req.payload},
+	}
+	p, err := m.Marshal(nil)
+	if err != nil {
+		return 0, replyMeta{}, nil, err
+	}
+	meta := replyMeta{ttl: 64, recv: r.req.start.Add(r.ev.delay)}
+	return copy(b, p), meta, &net.IPAddr{IP: r.ev.ip}, nil
+}
+
+// simulate feeds the requests scripted in path through the normal reply
+// matching and reporting, without opening any sockets.
+func simulate(path string) error {
+	events, err := parseSimulation(path)
+	if err != nil {
+		return err
+	}
+	seqs := make(map[string]int)
+	for _, ev := range events {
+		fam := familyIPv6
+		if ev.ip.To4() != nil {
+			fam = familyIPv4
+		}
+		k := ev.host + " " + ev.ip.String()
+		seqs[k]++
+		req := newRequest(ev.host, &net.IPAddr{IP: ev.ip}, seqs[k])
+		req.start = clock.Now()
+		hostEntry(ev.host)
+		r, err := readReply(&simReader{req: req, fam: fam, ev: ev}, fam, req)
+		if err != nil {
+			return err
+		}
+		handleResult(r)
+	}
+	return nil
+}
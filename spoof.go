@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+)
+
+// spoofSource is the parsed --spoof-source address, if set.
+var spoofSource net.IP
+
+// spoofTTL is the TTL of requests sent with --spoof-source.
+const spoofTTL = 64
+
+// sendSpoofed sends an echo request to each IPv4 address of addr with the
+// IP header built by hand, so that its source is spoofSource rather than an
+// address of this host. Any reply goes to the spoofed source, so nothing is
+// read back and no results are recorded.
+func sendSpoofed(addr string, ips []net.IP) error {
+	c, err := net.ListenPacket("ip4:icmp", *flagListen4)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	r, err := ipv4.NewRawConn(c)
+	if err != nil {
+		return err
+	}
+
+	for _, ip := range ips {
+		ip4 := ip.To4()
+		if ip4 == nil {
+			log.Printf("[%s] %s: skipping, --spoof-source only supports IPv4", addr, ip)
+			continue
+		}
+		req := newRequest(addr, &net.IPAddr{IP: ip4}, 1)
+		m := icmp.Message{
+			Type: ipv4.ICMPTypeEcho,
+			Code: 0,
+			Body: &icmp.Echo{
+				ID:   req.id,
+				Seq:  req.seq,
+				Data: req.payload,
+			},
+		}
+		wb, err := m.Marshal(nil)
+		if err != nil {
+			return err
+		}
+		h := &ipv4.Header{
+			Version:  ipv4.Version,
+			Len:      ipv4.HeaderLen,
+			TotalLen: ipv4.HeaderLen + len(wb),
+			TTL:      spoofTTL,
+			Protocol: ProtocolICMP,
+			Src:      spoofSource,
+			Dst:      ip4,
+		}
+		if err := r.WriteTo(h, wb, nil); err != nil {
+			return fmt.Errorf("sending to %s: %v", ip4, err)
+		}
+		log.Printf("[%s] %s: sent request from spoofed source %s; any reply will go there", addr, ip4, spoofSource)
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// addrSummary is the --summary-json-file entry for one resolved address of
+// a host, and the interface or source address it was pinged from, if any.
+// RTTs are in seconds, matching the InfluxDB output.
+type addrSummary struct {
+	Host          string   `json:"host"`
+	IP            string   `json:"ip"`
+	Iface         string   `json:"iface,omitempty"`
+	Egress        string   `json:"egress,omitempty"`
+	Family        string   `json:"family"`
+	Sent          int      `json:"sent"`
+	Received      int      `json:"received"`
+	Unreachable   int      `json:"unreachable"`
+	TTLBelowMin   int      `json:"ttl_below_min,omitempty"`
+	Loss          float64  `json:"loss"`
+	SentBytes     int      `json:"sent_bytes"`
+	ReceivedBytes int      `json:"received_bytes"`
+	RTTMin        *float64 `json:"rtt_min,omitempty"`
+	RTTAvg        *float64 `json:"rtt_avg,omitempty"`
+	RTTMax        *float64 `json:"rtt_max,omitempty"`
+	Jitter        *float64 `json:"jitter,omitempty"`
+	Tag           string   `json:"tag,omitempty"`
+	Label         string   `json:"label,omitempty"`
+
+	// Classes counts the replies in each --latency-classes band.
+	Classes map[string]int `json:"classes,omitempty"`
+
+	// NATDetected is set if any reply came back with a rewritten ICMP ID.
+	NATDetected bool `json:"nat_detected,omitempty"`
+}
+
+// summarize returns one summary per resolved address of every host, in
+// command-line order. Jitter is the mean difference between the RTTs of
+// consecutive replies, and is omitted with fewer than two replies.
+func summarize(hosts []*hostResults) []addrSummary {
+	sums := []addrSummary{}
+	for _, h := range hosts {
+		// Results from different interfaces or sources are kept apart.
+		type key struct{ ip, iface string }
+		var order []key
+		byAddr := make(map[key][]result)
+		for _, r := range h.results {
+			k := key{r.IP.String(), r.Iface}
+			if _, ok := byAddr[k]; !ok {
+				order = append(order, k)
+			}
+			byAddr[k] = append(byAddr[k], r)
+		}
+		for _, k := range order {
+			rs := byAddr[k]
+			s := addrSummary{Host: h.host, IP: k.ip, Iface: k.iface, Family: ipFamily(rs[0].IP), Sent: len(rs), Tag: rs[0].Tag}
+			var rtts []time.Duration
+			for _, r := range rs {
+				if r.Status == statusReply {
+					rtts = append(rtts, r.RTT)
+				}
+				if r.Status == statusUnreachable {
+					s.Unreachable++
+				}
+				s.SentBytes += r.SentBytes
+				s.ReceivedBytes += r.ReceivedBytes
+				if r.IDRewritten {
+					s.NATDetected = true
+				}
+				if r.Label != "" {
+					s.Label = r.Label
+				}
+				if r.TTLBelowMin {
+					s.TTLBelowMin++
+				}
+				if r.Egress != "" {
+					s.Egress = r.Egress
+				}
+				if r.Class != "" {
+					if s.Classes == nil {
+						s.Classes = make(map[string]int)
+					}
+					s.Classes[r.Class]++
+				}
+			}
+			s.Received = len(rtts)
+			lost := s.Sent - s.Received
+			if !*flagUnreachableDown {
+				lost -= s.Unreachable
+			}
+			s.Loss = float64(lost) / float64(s.Sent)
+			if len(rtts) > 0 {
+				min, max, total := rtts[0], rtts[0], time.Duration(0)
+				for _, rtt := range rtts {
+					if rtt < min {
+						min = rtt
+					}
+					if rtt > max {
+						max = rtt
+					}
+					total += rtt
+				}
+				s.RTTMin = seconds(min)
+				s.RTTMax = seconds(max)
+				s.RTTAvg = seconds(total / time.Duration(len(rtts)))
+			}
+			if len(rtts) > 1 {
+				var diff time.Duration
+				for i := 1; i < len(rtts); i++ {
+					d := rtts[i] - rtts[i-1]
+					if d < 0 {
+						d = -d
+					}
+					diff += d
+				}
+				s.Jitter = seconds(diff / time.Duration(len(rtts)-1))
+			}
+			sums = append(sums, s)
+		}
+	}
+	return sums
+}
+
+func seconds(d time.Duration) *float64 {
+	s := d.Seconds()
+	return &s
+}
+
+// writeSummaryJSON writes the summaries of hosts to path. The file is
+// written to a temporary name and renamed into place, so readers never see
+// a partial document.
+func writeSummaryJSON(path string, hosts []*hostResults) error {
+	b, err := json.MarshalIndent(summarize(hosts), "", "  ")
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// logByteCounts logs the ICMP bytes sent and received over the run, and
+// the goodput of the replies, for --count-received-bytes.
+func logByteCounts(hosts []*hostResults, elapsed time.Duration) {
+	var sent, received int
+	for _, h := range hosts {
+		for _, r := range h.results {
+			sent += r.SentBytes
+			received += r.ReceivedBytes
+		}
+	}
+	log.Printf("sent %d bytes, received %d bytes in %s (goodput %.0f bytes/s)", sent, received, formatRTT(elapsed), float64(received)/elapsed.Seconds())
+}
@@ -0,0 +1,12 @@
+//go:build windows || plan9
+// +build windows plan9
+
+package main
+
+import "errors"
+
+func openSyslog(addr, facility string) error {
+	return errors.New("syslog is not supported on this platform")
+}
+
+func sendSyslog(r result) {}
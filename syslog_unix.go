@@ -0,0 +1,73 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"log/syslog"
+	"strings"
+	"sync"
+)
+
+// syslogFacilities maps --syslog-facility names to their priorities.
+var syslogFacilities = map[string]syslog.Priority{
+	"kern": syslog.LOG_KERN, "user": syslog.LOG_USER, "mail": syslog.LOG_MAIL,
+	"daemon": syslog.LOG_DAEMON, "auth": syslog.LOG_AUTH, "syslog": syslog.LOG_SYSLOG,
+	"lpr": syslog.LOG_LPR, "news": syslog.LOG_NEWS, "uucp": syslog.LOG_UUCP,
+	"cron": syslog.LOG_CRON, "authpriv": syslog.LOG_AUTHPRIV, "ftp": syslog.LOG_FTP,
+	"local0": syslog.LOG_LOCAL0, "local1": syslog.LOG_LOCAL1, "local2": syslog.LOG_LOCAL2,
+	"local3": syslog.LOG_LOCAL3, "local4": syslog.LOG_LOCAL4, "local5": syslog.LOG_LOCAL5,
+	"local6": syslog.LOG_LOCAL6, "local7": syslog.LOG_LOCAL7,
+}
+
+var (
+	syslogWriter  *syslog.Writer
+	syslogErrOnce sync.Once
+)
+
+// openSyslog connects to the syslog daemon at addr, given as
+// "network://host:port", or to the local daemon if addr is empty.
+func openSyslog(addr, facility string) error {
+	pri, ok := syslogFacilities[facility]
+	if !ok {
+		return fmt.Errorf("unknown syslog facility %q", facility)
+	}
+	var network string
+	if addr != "" {
+		i := strings.Index(addr, "://")
+		if i < 0 {
+			return fmt.Errorf("invalid syslog address %q: want network://host:port", addr)
+		}
+		network, addr = addr[:i], addr[i+3:]
+	}
+	w, err := syslog.Dial(network, addr, pri|syslog.LOG_INFO, "quickping")
+	if err != nil {
+		return err
+	}
+	syslogWriter = w
+	return nil
+}
+
+// sendSyslog sends r to syslog, if --syslog is set, with a severity that
+// depends on its status.
+func sendSyslog(r result) {
+	if syslogWriter == nil {
+		return
+	}
+	var err error
+	switch msg := syslogLine(r); r.Status {
+	case statusReply:
+		err = syslogWriter.Info(msg)
+	case statusUnreachable:
+		err = syslogWriter.Err(msg)
+	default:
+		err = syslogWriter.Warning(msg)
+	}
+	if err != nil {
+		syslogErrOnce.Do(func() {
+			log.Printf("error: sending to syslog: %v", err)
+		})
+	}
+}
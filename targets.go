@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// targetsURLTimeout bounds the request made for --targets-url.
+const targetsURLTimeout = 30 * time.Second
+
+// targetLabels holds display labels for targets, from --targets-url.
+var targetLabels = make(map[string]string)
+
+// fetchTargets fetches the list of targets from url. The body is either a
+// JSON array, whose elements are host name strings or objects with "host"
+// and optional "label" fields, or plain text with one host per line; blank
+// lines and lines starting with '#' are ignored.
+func fetchTargets(url string, insecure bool) ([]string, error) {
+	client := &http.Client{Timeout: targetsURLTimeout}
+	if insecure {
+		client.Transport = &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		}
+	}
+
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("fetching targets: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching targets from %s: %s", url, resp.Status)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("fetching targets from %s: %v", url, err)
+	}
+
+	if b := bytes.TrimSpace(body); len(b) > 0 && b[0] == '[' {
+		return parseJSONTargets(b)
+	}
+
+	var targets []string
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		targets = append(targets, line)
+	}
+	return targets, scanner.Err()
+}
+
+func parseJSONTargets(b []byte) ([]string, error) {
+	var items []json.RawMessage
+	if err := json.Unmarshal(b, &items); err != nil {
+		return nil, fmt.Errorf("parsing targets: %v", err)
+	}
+
+	var targets []string
+	for i, item := range items {
+		var host string
+		if err := json.Unmarshal(item, &host); err == nil {
+			targets = append(targets, host)
+			continue
+		}
+
+		var obj struct {
+			Host  string `json:"host"`
+			Label string `json:"label"`
+		}
+		if err := json.Unmarshal(item, &obj); err != nil || obj.Host == "" {
+			return nil, fmt.Errorf("parsing targets: element %d is neither a host name nor an object with a \"host\" field", i)
+		}
+		targets = append(targets, obj.Host)
+		if obj.Label != "" {
+			targetLabels[obj.Host] = obj.Label
+		}
+	}
+	return targets, nil
+}
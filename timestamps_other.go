@@ -0,0 +1,20 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"errors"
+	"net"
+	"time"
+)
+
+var errKernelTimestampsUnsupported = errors.New("kernel timestamps are only supported on Linux")
+
+func enableKernelTimestamps(c *net.IPConn) error {
+	return errKernelTimestampsUnsupported
+}
+
+func parseKernelTimestamp(oob []byte) (time.Time, bool) {
+	return time.Time{}, false
+}